@@ -0,0 +1,123 @@
+package echo_limiter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Adaptive tightens the limits while the backend answers with many 5xx,
+// e.g. when it's overloaded, and restores them once a Window passes with
+// fewer errors. The error rate is tracked per instance, not distributed
+type Adaptive struct {
+	// Threshold is the share of 5xx responses tightening the limits
+	// Default: 0.5
+	Threshold float64
+
+	// Factor scales Max and Burst while tightened, at least 1 is kept
+	// Default: 0.5
+	Factor float64
+
+	// Window is the span the error rate is measured over
+	// Default: 10 seconds
+	Window time.Duration
+
+	// MinRequests is the number of responses in a Window needed before
+	// the error rate counts, so a single failure doesn't tighten
+	// Default: 10
+	MinRequests int
+}
+
+// adaptive tracks the error rate of the current window
+type adaptive struct {
+	Adaptive
+
+	mu        sync.Mutex
+	start     time.Time
+	total     int
+	failed    int
+	tightened bool
+}
+
+func newAdaptive(config Adaptive) *adaptive {
+	if config.Threshold == 0 {
+		config.Threshold = 0.5
+	}
+
+	if config.Factor == 0 {
+		config.Factor = 0.5
+	}
+
+	if config.Window == 0 {
+		config.Window = 10 * time.Second
+	}
+
+	if config.MinRequests == 0 {
+		config.MinRequests = 10
+	}
+
+	return &adaptive{Adaptive: config, start: time.Now()}
+}
+
+func (config Adaptive) validate() error {
+	if config.Threshold < 0 || config.Threshold > 1 {
+		return errors.New("adaptive threshold must be between 0 and 1")
+	}
+
+	if config.Factor < 0 || config.Factor > 1 {
+		return errors.New("adaptive factor must be between 0 and 1")
+	}
+
+	if config.Window < 0 || config.MinRequests < 0 {
+		return errors.New("adaptive window and min requests must not be negative")
+	}
+
+	return nil
+}
+
+// record counts a response, failed for a 5xx
+func (a *adaptive) record(failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roll(time.Now())
+
+	a.total++
+	if failed {
+		a.failed++
+	}
+
+	if a.spiking() {
+		// Tighten right away instead of waiting for the window to end
+		a.tightened = true
+	}
+}
+
+// scale returns the factor to apply to the limits right now
+func (a *adaptive) scale() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roll(time.Now())
+
+	if a.tightened {
+		return a.Factor
+	}
+
+	return 1
+}
+
+// roll starts a new window once the current one is over, the limits stay
+// tightened only when it was spiking
+func (a *adaptive) roll(now time.Time) {
+	if now.Sub(a.start) < a.Window {
+		return
+	}
+
+	a.tightened = a.spiking()
+	a.start, a.total, a.failed = now, 0, 0
+}
+
+func (a *adaptive) spiking() bool {
+	return a.total >= a.MinRequests && float64(a.failed) >= a.Threshold*float64(a.total)
+}
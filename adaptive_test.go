@@ -0,0 +1,68 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAdaptive(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []bool
+		want    float64
+	}{
+		{"no traffic", nil, 1},
+		{"too few", []bool{true}, 1},
+		{"healthy", []bool{false, false, false, true}, 1},
+		{"spike", []bool{false, true, true, false}, 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptive(Adaptive{Factor: 0.25, Window: time.Minute, MinRequests: 2})
+			for _, failed := range tt.records {
+				a.record(failed)
+			}
+
+			if got := a.scale(); got != tt.want {
+				t.Errorf("scale = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveRecovers(t *testing.T) {
+	a := newAdaptive(Adaptive{Window: 50 * time.Millisecond, MinRequests: 2})
+	a.record(true)
+	a.record(true)
+
+	steps := []float64{0.5, 0.5, 1}
+	for i, want := range steps {
+		if got := a.scale(); got != want {
+			t.Errorf("window %d: scale = %v, want %v", i, got, want)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	e := newStatusServer(t, Config{
+		Store:    NewMemoryStore(),
+		Max:      4,
+		Adaptive: &Adaptive{Window: time.Minute, MinRequests: 2},
+	})
+
+	for i := 0; i < 2; i++ {
+		serve(e, http.MethodGet, "/?status=500&error=1", echo.HeaderXRealIP, "198.51.100.1")
+	}
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	if got := codes(e, 3, echo.HeaderXRealIP, "198.51.100.2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
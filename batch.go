@@ -0,0 +1,193 @@
+package echo_limiter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+// batchScript runs gcraScript and slidingWindowScript for every key in
+// order, once a key denies the keys after it are read as with the peek
+// scripts. ARGV holds cost and warm_up, then algorithm, burst, rate and
+// period of every key
+var batchScript = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+
+local cost = tonumber(ARGV[1])
+local warm_up = tonumber(ARGV[2])
+
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+
+local function gcra(key, burst, rate, period, peek)
+  local emission_interval = period / rate
+  local burst_offset = emission_interval * burst
+
+  local tat = redis.call("GET", key)
+
+  if not tat then
+    tat = now + math.min(warm_up, burst_offset - emission_interval)
+  else
+    tat = tonumber(tat)
+  end
+
+  if peek then
+    tat = math.max(tat, now)
+
+    local diff = now - (tat - burst_offset)
+    local remaining = math.floor(diff / emission_interval + 0.5)
+
+    if remaining < 1 then
+      return {0, 0, tostring(emission_interval - diff), tostring(tat - now)}
+    end
+
+    return {1, remaining, "-1", tostring(tat - now)}
+  end
+
+  local new_tat = math.max(tat, now) + emission_interval * cost
+  local diff = now - (new_tat - burst_offset)
+  local remaining = math.floor(diff / emission_interval + 0.5)
+
+  if remaining < 0 then
+    return {0, 0, tostring(diff * -1), tostring(tat - now)}
+  end
+
+  local reset_after = new_tat - now
+  if reset_after > 0 then
+    redis.call("SET", key, new_tat, "EX", math.ceil(reset_after))
+  else
+    redis.call("DEL", key)
+  end
+
+  return {1, remaining, "-1", tostring(reset_after)}
+end
+
+local function sliding_window(key, rate, period, peek)
+  if peek then
+    local clear_before = "(" .. string.format("%.6f", now - period)
+    local count = redis.call("ZCOUNT", key, clear_before, "+inf")
+    local remaining = math.max(rate - count, 0)
+    local retry_after = -1
+
+    if count >= rate then
+      local oldest = redis.call("ZRANGEBYSCORE", key, clear_before, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+      retry_after = tonumber(oldest[2]) + period - now
+    end
+
+    return {remaining > 0 and 1 or 0, remaining, tostring(retry_after), tostring(period)}
+  end
+
+  redis.call("ZREMRANGEBYSCORE", key, "0.0", string.format("%.6f", now - period))
+
+  local count = redis.call("ZCARD", key)
+  local oldest = redis.call("ZRANGEBYSCORE", key, "0.0", "+inf", "WITHSCORES", "LIMIT", 0, 1)
+  local retry_after = period
+
+  if #oldest > 0 then
+    retry_after = period - (now - tonumber(oldest[2]))
+  end
+
+  if count + cost > rate then
+    return {0, math.max(rate - count, 0), tostring(retry_after), tostring(period)}
+  end
+
+  local score = string.format("%.6f", now)
+  for i = 1, cost do
+    redis.call("ZADD", key, score, score .. ":" .. (count + i))
+  end
+  redis.call("EXPIRE", key, math.ceil(period))
+
+  return {1, rate - (count + cost), tostring(retry_after), tostring(period)}
+end
+
+local results = {}
+local denied = false
+
+for i, key in ipairs(KEYS) do
+  local at = 2 + (i - 1) * 4
+  local algorithm = tonumber(ARGV[at + 1])
+  local burst = tonumber(ARGV[at + 2])
+  local rate = tonumber(ARGV[at + 3])
+  local period = tonumber(ARGV[at + 4])
+
+  local result
+  if algorithm == 1 then
+    result = gcra(key, burst, rate, period, denied)
+  else
+    result = sliding_window(key, rate, period, denied)
+  end
+
+  if result[1] == 0 then
+    denied = true
+  end
+
+  results[i] = result
+end
+
+return results
+`)
+
+// AllowAllN implements BatchStore, running every key in a single script.
+// A refund, negative n, goes through AllowN key by key
+func (s *redisStore) AllowAllN(ctx context.Context, keys []string, limits []*go_limiter.Limit, n int) ([]*go_limiter.Result, error) {
+	if n < 0 {
+		results := make([]*go_limiter.Result, len(keys))
+		for i, key := range keys {
+			r, err := s.AllowN(ctx, key, limits[i], n)
+			if err != nil {
+				return nil, err
+			}
+
+			results[i] = r
+		}
+
+		return results, nil
+	}
+
+	values := make([]interface{}, 0, 2+4*len(limits))
+	values = append(values, n, warmUp(ctx).Seconds())
+
+	for _, limit := range limits {
+		if _, ok := go_limiter.GetAlgorithmName(limit.Algorithm); !ok {
+			return nil, errAlgorithmNotSupported
+		}
+
+		values = append(values, limit.Algorithm, limit.Burst, limit.Rate, limit.Period.Seconds())
+	}
+
+	v, err := batchScript.run(ctx, s.scripter, keys, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := v.([]interface{})
+	results := make([]*go_limiter.Result, len(replies))
+
+	for i, reply := range replies {
+		values := reply.([]interface{})
+
+		retryAfter, err := strconv.ParseFloat(values[2].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		resetAfter, err := strconv.ParseFloat(values[3].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = &go_limiter.Result{
+			Limit:      limits[i],
+			Key:        keys[i],
+			Allowed:    values[0].(int64) == 1,
+			Remaining:  values[1].(int64),
+			RetryAfter: dur(retryAfter),
+			ResetAfter: dur(resetAfter),
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,96 @@
+package echo_limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// countingScripter counts the scripts run, a round-trip each once redis
+// cached them. The Eval retries of NOSCRIPT replies aren't counted,
+// miniredis doesn't cache the scripts of EVAL
+type countingScripter struct {
+	Scripter
+	calls int64
+}
+
+func (s *countingScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	atomic.AddInt64(&s.calls, 1)
+	return s.Scripter.EvalSha(ctx, sha1, keys, args...)
+}
+
+// batchConfig is a config with three limits, two rules and GlobalMax
+func batchConfig(scripter Scripter, algorithm uint, batch bool) Config {
+	return Config{
+		Scripter:     scripter,
+		Algorithm:    algorithm,
+		Batch:        batch,
+		Limits:       []LimitRule{{Max: 3, Period: time.Minute}, {Max: 5, Period: time.Hour}},
+		GlobalMax:    8,
+		GlobalPeriod: time.Minute,
+	}
+}
+
+func TestBatch(t *testing.T) {
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		t.Run(fmt.Sprint(algorithm), func(t *testing.T) {
+			var (
+				responses [2][]string
+				calls     [2]int64
+			)
+
+			for i, batch := range []bool{false, true} {
+				_, client := newRedis(t)
+				scripter := &countingScripter{Scripter: NewScripter(client)}
+				e := newServer(t, batchConfig(scripter, algorithm, batch))
+
+				for k := 0; k < 12; k++ {
+					rec := serve(e, http.MethodGet, "/", echo.HeaderXRealIP, fmt.Sprint("198.51.100.", k%3))
+
+					// Denials by several limits report the longest
+					// Retry-After, a near tie between two of them may go
+					// either way, so their limit isn't compared
+					response := fmt.Sprint(rec.Code, " remaining ", rec.Header().Get(defaultRemainingHeader))
+					if rec.Code == http.StatusOK {
+						response += " of " + rec.Header().Get(defaultLimitHeader)
+					}
+
+					responses[i] = append(responses[i], response)
+				}
+
+				calls[i] = atomic.LoadInt64(&scripter.calls)
+			}
+
+			if !reflect.DeepEqual(responses[0], responses[1]) {
+				t.Errorf("batch responses %v, want the sequential %v", responses[1], responses[0])
+			}
+
+			if calls[1] != 12 || calls[0] <= calls[1] {
+				t.Errorf("%d round-trips sequentially and %d batched, want fewer and 12", calls[0], calls[1])
+			}
+		})
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch=%v", batch), func(b *testing.B) {
+			_, client := newRedis(b)
+			scripter := &countingScripter{Scripter: NewScripter(client)}
+			e := newServer(b, batchConfig(scripter, GCRAAlgorithm, batch))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				serve(e, http.MethodGet, "/", echo.HeaderXRealIP, fmt.Sprint("198.51.100.", i%250))
+			}
+
+			b.ReportMetric(float64(atomic.LoadInt64(&scripter.calls))/float64(b.N), "round-trips/op")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package echo_limiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BodyKey returns a Key func computing the key from the first max bytes
+// of the request body with f, e.g. a field of a GraphQL request. Those
+// bytes are held in memory for the request and put back in front of the
+// rest of the stream, so the handler still reads the whole body. max caps
+// the memory, a body longer than that gets its first max bytes only, as
+// do bodies f can't parse. An empty result and a failed read are keyed by
+// ctx.RealIP()
+func BodyKey(max int64, f func(body []byte) string) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		req := ctx.Request()
+		if req.Body == nil || req.Body == http.NoBody {
+			return ctx.RealIP()
+		}
+
+		body, err := peekBody(req, max)
+		if err != nil {
+			return ctx.RealIP()
+		}
+
+		if key := f(body); key != "" {
+			return key
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// JSONBodyKey returns a BodyKey limiting by the string value of the top
+// level JSON field, e.g. "operationName" of GraphQL requests. The value is
+// client supplied, combine it with an IP or user key through KeyParts to
+// keep clients from sharing buckets
+func JSONBodyKey(field string, max int64) func(echo.Context) string {
+	return BodyKey(max, func(body []byte) string {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+
+		var v string
+		if err := json.Unmarshal(fields[field], &v); err != nil {
+			return ""
+		}
+
+		return v
+	})
+}
+
+// peekBody reads the first max bytes of the body of req and puts them
+// back in front of the rest of the stream
+func peekBody(req *http.Request, max int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, max))
+	req.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+
+	return body, err
+}
+
+// replayBody is a request body read again from the start
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
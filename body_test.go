@@ -0,0 +1,81 @@
+package echo_limiter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestJSONBodyKey(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		max  int64
+		want string
+	}{
+		{"field", `{"operationName":"GetUser","query":"{ user }"}`, 1024, "GetUser"},
+		{"missing field", `{"query":"{ user }"}`, 1024, "192.0.2.1"},
+		{"not a string", `{"operationName":42}`, 1024, "192.0.2.1"},
+		{"not json", `operationName=GetUser`, 1024, "192.0.2.1"},
+		{"over the cap", `{"operationName":"GetUser"}`, 10, "192.0.2.1"},
+		{"empty", ``, 1024, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			ctx := echo.New().NewContext(req, httptest.NewRecorder())
+
+			if got := JSONBodyKey("operationName", tt.max)(ctx); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+
+			// The handler still reads the whole body
+			body, err := ioutil.ReadAll(ctx.Request().Body)
+			if err != nil || string(body) != tt.body {
+				t.Errorf("body after the key = %q (%v), want %q", body, err, tt.body)
+			}
+		})
+	}
+}
+
+func TestBodyKeyMiddleware(t *testing.T) {
+	m, err := NewWithConfigE(Config{Store: NewMemoryStore(), Max: 1, Key: JSONBodyKey("operationName", 1024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.POST("/graphql", func(ctx echo.Context) error {
+		body, err := ioutil.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return err
+		}
+
+		return ctx.String(http.StatusOK, string(body))
+	})
+
+	tests := []struct {
+		operation string
+		want      int
+	}{
+		{"GetUser", http.StatusOK},
+		{"GetUser", http.StatusTooManyRequests},
+		{"ListUsers", http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		body := `{"operationName":"` + tt.operation + `"}`
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+
+		if rec.Code != tt.want || (tt.want == http.StatusOK && rec.Body.String() != body) {
+			t.Errorf("request %d: code %d body %q, want %d", i, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package echo_limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// cardinalityScript records the member in the set of a source unless
+	// the set is full, returning 1 for a new member of a full set. The set
+	// lives for ARGV[3] seconds from its first member
+	cardinalityScript = newScript(`
+local key = KEYS[1]
+local member = ARGV[1]
+local max = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+if redis.call("SISMEMBER", key, member) == 1 then
+  return 0
+end
+
+if redis.call("SCARD", key) >= max then
+  return 1
+end
+
+redis.call("SADD", key, member)
+if redis.call("TTL", key) < 0 then
+  redis.call("EXPIRE", key, window)
+end
+
+return 0
+`)
+)
+
+// Cardinality guards the store against a client minting keys, e.g. random
+// values of the header a Key reads, each of them a fresh bucket. Once a
+// Source used Max distinct keys in a Window, its requests with any other
+// key are keyed by the Source itself, or denied with Deny, until the
+// Window ends. The keys it already used keep their buckets, Blacklist and
+// Whitelist are checked on the key before the guard runs. It's a
+// heuristic: sources behind a shared NAT share the count and a client
+// rotating sources, e.g. IPs, isn't caught. Tracked in redis, in memory
+// with a custom Store
+type Cardinality struct {
+	// Source is the coarse identity keys are counted for
+	// Default: ctx.RealIP()
+	Source func(echo.Context) string
+
+	// Max is the number of distinct keys a Source may use per Window
+	// Default: 100
+	Max int
+
+	// Window is how long the keys of a Source are counted, from the first
+	// one
+	// Default: 1 minute
+	Window time.Duration
+
+	// Deny denies the requests over Max like for Blacklist instead of
+	// keying them by the Source
+	// Default: false
+	Deny bool
+}
+
+type (
+	// cardinality counts the distinct keys of the sources
+	cardinality interface {
+		// exceeded records key for source, reporting whether it's a new
+		// key of a source over its max
+		exceeded(ctx context.Context, source, key string) (bool, error)
+	}
+
+	redisCardinality struct {
+		scripter Scripter
+		prefix   string
+		max      int
+		window   time.Duration
+	}
+
+	memoryCardinality struct {
+		mu      sync.Mutex
+		max     int
+		window  time.Duration
+		sources map[string]*sourceKeys
+		sweep   time.Time
+	}
+
+	sourceKeys struct {
+		start time.Time
+		keys  map[string]struct{}
+	}
+)
+
+func (config Cardinality) validate() error {
+	if config.Max < 0 || config.Window < 0 {
+		return errors.New("cardinality max and window must not be negative")
+	}
+
+	if config.Window > 0 && config.Window < time.Second {
+		return errors.New("cardinality window must be at least 1s")
+	}
+
+	return nil
+}
+
+func (config Cardinality) withDefaults() Cardinality {
+	if config.Source == nil {
+		config.Source = func(ctx echo.Context) string {
+			return ctx.RealIP()
+		}
+	}
+
+	if config.Max == 0 {
+		config.Max = 100
+	}
+
+	if config.Window == 0 {
+		config.Window = time.Minute
+	}
+
+	return config
+}
+
+func (c *redisCardinality) exceeded(ctx context.Context, source, key string) (bool, error) {
+	// Keys are hashed, they may be long or carry secrets
+	v, err := cardinalityScript.run(ctx, c.scripter, []string{c.prefix + source}, hash(key), c.max, int64(c.window.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	return v.(int64) == 1, nil
+}
+
+func newMemoryCardinality(max int, window time.Duration) *memoryCardinality {
+	return &memoryCardinality{
+		max:     max,
+		window:  window,
+		sources: make(map[string]*sourceKeys),
+	}
+}
+
+func (c *memoryCardinality) exceeded(_ context.Context, source, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expire(now)
+
+	keys, ok := c.sources[source]
+	if !ok || now.Sub(keys.start) >= c.window {
+		keys = &sourceKeys{start: now, keys: make(map[string]struct{})}
+		c.sources[source] = keys
+	}
+
+	if _, ok := keys.keys[key]; ok {
+		return false, nil
+	}
+
+	if len(keys.keys) >= c.max {
+		return true, nil
+	}
+
+	keys.keys[key] = struct{}{}
+
+	return false, nil
+}
+
+// expire drops the sources whose window ended, at most once a minute
+func (c *memoryCardinality) expire(now time.Time) {
+	if now.Before(c.sweep) {
+		return
+	}
+
+	for source, keys := range c.sources {
+		if now.Sub(keys.start) >= c.window {
+			delete(c.sources, source)
+		}
+	}
+
+	c.sweep = now.Add(time.Minute)
+}
@@ -0,0 +1,106 @@
+package echo_limiter
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCardinalities(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name string
+		c    cardinality
+	}{
+		{"redis", &redisCardinality{scripter: NewScripter(client), prefix: "cardinality:", max: 2, window: time.Minute}},
+		{"memory", newMemoryCardinality(2, time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := []struct {
+				source, key string
+				want        bool
+			}{
+				{"s", "a", false},
+				{"s", "b", false},
+				{"s", "c", true},
+				{"s", "a", false},
+				{"s", "c", true},
+				{"other", "c", false},
+			}
+
+			for i, step := range steps {
+				if got, err := tt.c.exceeded(context.Background(), step.source, step.key); err != nil || got != step.want {
+					t.Errorf("step %d: exceeded(%s, %s) = %v (%v), want %v", i, step.source, step.key, got, err, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCardinality(t *testing.T) {
+	tests := []struct {
+		name     string
+		deny     bool
+		key      string
+		wantCode int
+		wantKey  string
+	}{
+		{"known key", false, "a", http.StatusOK, "a"},
+		{"source key", false, "c", http.StatusOK, "192.0.2.1"},
+		{"denied", true, "c", http.StatusTooManyRequests, "c"},
+		{"blacklisted", false, "black", http.StatusTooManyRequests, "black"},
+		{"whitelisted", true, "white", http.StatusOK, "white"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{
+				Store:          NewMemoryStore(),
+				Key:            func(ctx echo.Context) string { return ctx.Request().Header.Get("Key") },
+				Cardinality:    &Cardinality{Max: 2, Deny: tt.deny},
+				Blacklist:      []string{"black"},
+				Whitelist:      []string{"white"},
+				DebugKeyHeader: "X-RateLimit-Key",
+			})
+
+			codes(e, 1, "Key", "a")
+			codes(e, 1, "Key", "b")
+
+			rec := serve(e, http.MethodGet, "/", "Key", tt.key)
+			if got := rec.Header().Get("X-RateLimit-Key"); rec.Code != tt.wantCode || got != tt.wantKey {
+				t.Errorf("code %d key %q, want %d %q", rec.Code, got, tt.wantCode, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCardinalityFallback(t *testing.T) {
+	mr, client := newRedis(t)
+	e := newServer(t, Config{
+		Rediser:          client,
+		FallbackToMemory: true,
+		Logger:           discard{},
+		Key:              func(ctx echo.Context) string { return ctx.Request().Header.Get("Key") },
+		Cardinality:      &Cardinality{Max: 2, Deny: true},
+	})
+	mr.Close()
+
+	// The in-process count still denies the third key
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	got := []int{
+		serve(e, http.MethodGet, "/", "Key", "a").Code,
+		serve(e, http.MethodGet, "/", "Key", "b").Code,
+		serve(e, http.MethodGet, "/", "Key", "c").Code,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
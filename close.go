@@ -0,0 +1,76 @@
+package echo_limiter
+
+import (
+	"io"
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/labstack/echo/v4"
+)
+
+// closer closes c at most once
+type closer struct {
+	once sync.Once
+	c    io.Closer
+	err  error
+}
+
+// NewWithCloser is like NewWithConfigE but also returns a Closer to
+// release on shutdown what the middleware created itself. A client set in
+// the config is owned by the caller and left open, then Close is a no-op.
+// Close is safe to call more than once
+func NewWithCloser(config Config) (echo.MiddlewareFunc, io.Closer, error) {
+	m, l, err := Build(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, l, nil
+}
+
+// Close implements io.Closer
+func (c *closer) Close() error {
+	c.once.Do(func() {
+		if c.c != nil {
+			c.err = c.c.Close()
+		}
+	})
+
+	return c.err
+}
+
+// NewFromURL returns the middleware with the default config for a redis
+// client built from url, e.g. "redis://:password@localhost:6379/0", with
+// opts applied in order. An invalid url or an unreachable redis is
+// returned as error. The client is owned by the middleware, use
+// WithShutdown to close it with the echo server
+func NewFromURL(url string, opts ...Option) (echo.MiddlewareFunc, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	config := configWith(opts)
+	config.Rediser = client
+	config.owned = client
+
+	m, c, err := NewWithCloser(config)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	if config.shutdown != nil {
+		config.shutdown.Server.RegisterOnShutdown(func() {
+			_ = c.Close()
+		})
+	}
+
+	return m, nil
+}
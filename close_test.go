@@ -0,0 +1,84 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNewWithCloser(t *testing.T) {
+	mr, client := newRedis(t)
+	owned := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	tests := []struct {
+		name       string
+		config     Config
+		wantClosed bool
+	}{
+		{"caller's client", Config{Rediser: client}, false},
+		{"owned client", Config{Rediser: owned, owned: owned}, true},
+		{"store", Config{Store: NewMemoryStore()}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c, err := NewWithCloser(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := c.Close(); err != nil {
+					t.Errorf("close %d: %v", i, err)
+				}
+			}
+
+			if tt.config.Rediser == nil {
+				return
+			}
+
+			if closed := tt.config.Rediser.Ping().Err() != nil; closed != tt.wantClosed {
+				t.Errorf("client closed %v, want %v", closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestNewFromURL(t *testing.T) {
+	mr, _ := newRedis(t)
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"valid", "redis://" + mr.Addr() + "/0", false},
+		{"malformed", "http://" + mr.Addr(), true},
+		{"unreachable", "redis://127.0.0.1:1/0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewFromURL(tt.url, WithMax(1))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			e := echo.New()
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error { return nil })
+
+			want := []int{http.StatusOK, http.StatusTooManyRequests}
+			if got := codes(e, 2); !reflect.DeepEqual(got, want) {
+				t.Errorf("codes = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package echo_limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+var (
+	// acquireScript adds the request ARGV[1] to the in-flight set unless
+	// it holds ARGV[2] requests already, requests older than ARGV[3] ms are
+	// dropped first so a crashed instance doesn't hold slots forever
+	acquireScript = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+
+local now = redis.call("TIME")
+now = now[1] * 1000 + math.floor(now[2] / 1000)
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - tonumber(ARGV[3]))
+
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+  return 0
+end
+
+redis.call("ZADD", KEYS[1], now, ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+
+return 1
+`)
+
+	// releaseScript removes the request ARGV[1] from the in-flight set
+	releaseScript = newScript(`return redis.call("ZREM", KEYS[1], ARGV[1])`)
+)
+
+type (
+	// inflight counts the requests in flight per key, see ConcurrencyMax
+	inflight interface {
+		// acquire takes a slot of key, the id releases it
+		acquire(ctx context.Context, key string) (id string, ok bool, err error)
+
+		// release gives the slot id of key back
+		release(ctx context.Context, key, id string) error
+	}
+
+	redisInflight struct {
+		scripter Scripter
+		max      int
+		timeout  time.Duration
+	}
+
+	memoryInflight struct {
+		max int
+
+		mu    sync.Mutex
+		count map[string]int
+	}
+)
+
+func (f *redisInflight) acquire(ctx context.Context, key string) (string, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+
+	id := hex.EncodeToString(b)
+
+	v, err := acquireScript.run(ctx, f.scripter, []string{key}, id, f.max, f.timeout.Milliseconds())
+	if err != nil {
+		return "", false, err
+	}
+
+	return id, v.(int64) == 1, nil
+}
+
+func (f *redisInflight) release(ctx context.Context, key, id string) error {
+	_, err := releaseScript.run(ctx, f.scripter, []string{key}, id)
+	return err
+}
+
+func (f *memoryInflight) acquire(_ context.Context, key string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.count[key] >= f.max {
+		return "", false, nil
+	}
+
+	f.count[key]++
+
+	return "", true, nil
+}
+
+func (f *memoryInflight) release(_ context.Context, key, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.count[key]--; f.count[key] <= 0 {
+		delete(f.count, key)
+	}
+
+	return nil
+}
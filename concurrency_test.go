@@ -0,0 +1,76 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/echo_limiter/testutil"
+)
+
+func TestConcurrencyMax(t *testing.T) {
+	_, client := newRedis(t)
+
+	down, downClient := newRedis(t)
+	down.Close()
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"redis", Config{Rediser: client, Max: 100}},
+		{"memory", Config{Store: testutil.AllowAll()}},
+		{"redis down", Config{Rediser: downClient, Max: 100, FallbackToMemory: true, Logger: discard{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.ConcurrencyMax = 2
+
+			m, err := NewWithConfigE(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entered, unblock := make(chan struct{}), make(chan struct{})
+
+			e := echo.New()
+			e.Use(m)
+			e.GET("/slow", func(ctx echo.Context) error {
+				entered <- struct{}{}
+				<-unblock
+				return ctx.NoContent(http.StatusOK)
+			})
+			e.GET("/", func(ctx echo.Context) error {
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					serve(e, http.MethodGet, "/slow")
+				}()
+				<-entered
+			}
+
+			if got := serve(e, http.MethodGet, "/").Code; got != http.StatusTooManyRequests {
+				t.Errorf("code with 2 in flight = %d, want 429", got)
+			}
+
+			close(unblock)
+			wg.Wait()
+
+			if got := serve(e, http.MethodGet, "/").Code; got != http.StatusOK {
+				t.Errorf("code after release = %d, want 200", got)
+			}
+
+			// The denied request took no tokens
+			if store, ok := tt.config.Store.(*testutil.Store); ok && len(store.Calls()) != 3 {
+				t.Errorf("store calls = %d, want 3", len(store.Calls()))
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package echo_limiter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+// ParseAlgorithm returns the algorithm named s, "sliding_window" or "gcra"
+// in any case, e.g. from a config file
+func ParseAlgorithm(s string) (uint, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "sliding_window":
+		return SlidingWindowAlgorithm, nil
+	case "gcra":
+		return GCRAAlgorithm, nil
+	}
+
+	return 0, fmt.Errorf("unknown algorithm %q", s)
+}
+
+// ParseRate returns the rule of a rate string, "<max>/<period>" like
+// "100/1m", "10/1s" or "1000/1h", the period is a time.Duration and a
+// bare unit counts once, "100/m" is "100/1m". The other fields of the rule
+// are left to their defaults
+func ParseRate(s string) (LimitRule, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 2 {
+		return LimitRule{}, fmt.Errorf("rate %q is not <max>/<period>", s)
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || max < 1 {
+		return LimitRule{}, fmt.Errorf("rate %q: max must be a positive integer", s)
+	}
+
+	unit := strings.TrimSpace(parts[1])
+	if unit != "" && unit[0] >= 'a' && unit[0] <= 'z' {
+		unit = "1" + unit
+	}
+
+	period, err := time.ParseDuration(unit)
+	if err != nil {
+		return LimitRule{}, fmt.Errorf("rate %q: %w", s, err)
+	}
+
+	if period <= 0 {
+		return LimitRule{}, fmt.Errorf("rate %q: period must be positive", s)
+	}
+
+	return LimitRule{Max: max, Period: period}, nil
+}
+
+// String returns the rate of the rule like ParseRate reads it, e.g.
+// "100/1m" or "10/1m30s"
+func (rule LimitRule) String() string {
+	period := rule.Period.String()
+	if strings.HasSuffix(period, "m0s") {
+		period = strings.TrimSuffix(period, "0s")
+	}
+
+	if strings.HasSuffix(period, "h0m") {
+		period = strings.TrimSuffix(period, "0m")
+	}
+
+	return strconv.Itoa(rule.Max) + "/" + period
+}
+
+// validate reports configuration mistakes the zero value defaults of
+// NewWithConfig would otherwise hide
+func (config Config) validate() error {
+	if config.StatusCode != 0 && (config.StatusCode < http.StatusBadRequest || config.StatusCode > 599) {
+		return fmt.Errorf("status code %d is not an error status", config.StatusCode)
+	}
+
+	if config.ErrorStatusCode != 0 && (config.ErrorStatusCode < http.StatusBadRequest || config.ErrorStatusCode > 599) {
+		return fmt.Errorf("error status code %d is not an error status", config.ErrorStatusCode)
+	}
+
+	if config.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	if config.GlobalMax < 0 || config.GlobalPeriod < 0 {
+		return errors.New("global max and period must not be negative")
+	}
+
+	if config.GlobalPeriod > 0 && config.GlobalPeriod < time.Microsecond {
+		return errors.New("global period must be at least 1µs")
+	}
+
+	for _, pattern := range append(append([]string{}, config.Include...), config.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	for i, rule := range config.Escalation {
+		if rule.Violations < 1 || rule.Window < time.Millisecond || rule.Ban < time.Millisecond {
+			return fmt.Errorf("escalation[%d]: violations must be at least 1, window and ban at least 1ms", i)
+		}
+	}
+
+	if config.OnMissingKey > MissingKeySkip {
+		return fmt.Errorf("missing key behavior %d is not supported", config.OnMissingKey)
+	}
+
+	if config.Mode != RejectMode && config.Mode != DelayMode {
+		return fmt.Errorf("mode %d is not supported", config.Mode)
+	}
+
+	if config.ConcurrencyMax < 0 || config.ConcurrencyTimeout < 0 {
+		return errors.New("concurrency max and timeout must not be negative")
+	}
+
+	if config.ConcurrencyTimeout > 0 && config.ConcurrencyTimeout < time.Millisecond {
+		return errors.New("concurrency timeout must be at least 1ms")
+	}
+
+	if config.QuietPeriod < 0 {
+		return errors.New("quiet period must not be negative")
+	}
+
+	if config.IdempotencyWindow < 0 {
+		return errors.New("idempotency window must not be negative")
+	}
+
+	if config.IdempotencyWindow > 0 && config.IdempotencyWindow < time.Millisecond {
+		return errors.New("idempotency window must be at least 1ms")
+	}
+
+	if config.IdempotencyMaxReplays < 0 {
+		return errors.New("idempotency max replays must not be negative")
+	}
+
+	if config.WarmUp < 0 {
+		return errors.New("warm up must not be negative")
+	}
+
+	if config.MaxDelay < 0 {
+		return errors.New("max delay must not be negative")
+	}
+
+	if config.WarnThreshold < 0 || config.WarnThreshold > 1 {
+		return errors.New("warn threshold must be between 0 and 1")
+	}
+
+	if config.ContentLengthUnit < 0 {
+		return errors.New("content length unit must not be negative")
+	}
+
+	if config.Adaptive != nil {
+		if err := config.Adaptive.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.Cardinality != nil {
+		if err := config.Cardinality.validate(); err != nil {
+			return err
+		}
+	}
+
+	max := config.Max
+	if max == 0 {
+		max = DefaultConfig.Max
+	}
+
+	algorithm := config.Algorithm
+	if algorithm == 0 {
+		algorithm = DefaultConfig.Algorithm
+	}
+
+	if err := validateRule(config.Max, config.Burst, config.Period, algorithm, max); err != nil {
+		return err
+	}
+
+	if config.BurstPeriod != 0 {
+		period := config.Period
+		if period == 0 {
+			period = DefaultConfig.Period
+		}
+
+		switch {
+		case config.BurstPeriod < 0:
+			return errors.New("burst period must not be negative")
+		case algorithm != go_limiter.GCRAAlgorithm:
+			return errors.New("burst period is only supported by gcra")
+		case config.Burst != 0:
+			return errors.New("burst and burst period can't be combined")
+		case config.BurstPeriod > period:
+			return fmt.Errorf("burst period %s is longer than period %s", config.BurstPeriod, period)
+		}
+	}
+
+	for i, rule := range config.Limits {
+		if err := rule.validate(max, algorithm); err != nil {
+			return fmt.Errorf("limits[%d]: %w", i, err)
+		}
+	}
+
+	for method, rule := range config.MethodLimits {
+		if err := rule.validate(max, algorithm); err != nil {
+			return fmt.Errorf("method limits[%s]: %w", method, err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the rule, max and algorithm are the ones of the config
+// its zero fields are taken from
+func (rule LimitRule) validate(max int, algorithm uint) error {
+	ruleMax, ruleAlgorithm := rule.Max, rule.Algorithm
+	if ruleMax == 0 {
+		ruleMax = max
+	}
+
+	if ruleAlgorithm == 0 {
+		ruleAlgorithm = algorithm
+	}
+
+	return validateRule(rule.Max, rule.Burst, rule.Period, ruleAlgorithm, ruleMax)
+}
+
+// validateRule checks the fields of a single limit, effectiveMax is max
+// after defaults were applied
+func validateRule(max, burst int, period time.Duration, algorithm uint, effectiveMax int) error {
+	if max < 0 {
+		return errors.New("max must not be negative")
+	}
+
+	if burst < 0 {
+		if algorithm == go_limiter.GCRAAlgorithm {
+			return fmt.Errorf("gcra burst %d must be at least 1", burst)
+		}
+
+		return errors.New("burst must not be negative")
+	}
+
+	if period < 0 {
+		return errors.New("period must not be negative")
+	}
+
+	// The scripts measure time with redis TIME, in microseconds
+	if period > 0 && period < time.Microsecond {
+		return fmt.Errorf("period %s is below the resolution of 1µs", period)
+	}
+
+	if _, ok := go_limiter.GetAlgorithmName(algorithm); !ok {
+		return errAlgorithmNotSupported
+	}
+
+	if algorithm == go_limiter.GCRAAlgorithm && burst > effectiveMax {
+		return fmt.Errorf("gcra burst %d is greater than max %d", burst, effectiveMax)
+	}
+
+	return nil
+}
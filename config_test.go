@@ -0,0 +1,297 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{}, false},
+		{"status code", Config{StatusCode: 200}, true},
+		{"error status code", Config{ErrorStatusCode: 302}, true},
+		{"negative timeout", Config{Timeout: -1}, true},
+		{"negative max", Config{Max: -1}, true},
+		{"negative period", Config{Period: -time.Second}, true},
+		{"period below 1µs", Config{Period: time.Nanosecond}, true},
+		{"negative global max", Config{GlobalMax: -1}, true},
+		{"pattern", Config{Include: []string{"/api/["}}, true},
+		{"gcra negative burst", Config{Algorithm: GCRAAlgorithm, Burst: -1}, true},
+		{"gcra burst over max", Config{Algorithm: GCRAAlgorithm, Max: 2, Burst: 3}, true},
+		{"sliding window burst over max", Config{Algorithm: SlidingWindowAlgorithm, Max: 2, Burst: 3}, false},
+		{"algorithm", Config{Algorithm: 42}, true},
+		{"limits", Config{Limits: []LimitRule{{Max: 1}, {Max: -1}}}, true},
+		{"method limits", Config{MethodLimits: map[string]LimitRule{"POST": {Period: -1}}}, true},
+		{"mode", Config{Mode: 7}, true},
+		{"warn threshold", Config{WarnThreshold: 2}, true},
+		{"negative warm up", Config{WarmUp: -1}, true},
+		{"negative idempotency max replays", Config{IdempotencyMaxReplays: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = NewMemoryStore()
+
+			if _, err := NewWithConfigE(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGCRABurst(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int
+		burst int
+		want  int
+	}{
+		{"defaults to max", 3, 0, 3},
+		{"one", 3, 1, 1},
+		{"below max", 3, 2, 2},
+		{"max", 3, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{
+				Store:     NewMemoryStore(),
+				Max:       tt.max,
+				Burst:     tt.burst,
+				Period:    time.Hour,
+				Algorithm: GCRAAlgorithm,
+			})
+
+			allowed := 0
+			for _, code := range codes(e, tt.max+1) {
+				if code == http.StatusOK {
+					allowed++
+				}
+			}
+
+			if allowed != tt.want {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint
+		wantErr bool
+	}{
+		{"sliding_window", SlidingWindowAlgorithm, false},
+		{"GCRA", GCRAAlgorithm, false},
+		{" gcra ", GCRAAlgorithm, false},
+		{"leaky", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAlgorithm(tt.s)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseAlgorithm(%q) = %d, %v, want %d, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAlgorithmName(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm uint
+		algName   string
+		wantErr   bool
+	}{
+		{"name", 0, "gcra", false},
+		{"same", GCRAAlgorithm, "gcra", false},
+		{"conflict", SlidingWindowAlgorithm, "gcra", true},
+		{"unknown", 0, "leaky", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithConfigE(Config{Store: NewMemoryStore(), Algorithm: tt.algorithm, AlgorithmName: tt.algName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBurstPeriod(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name        string
+		config      Config
+		wantAllowed int
+		wantErr     bool
+	}{
+		{"redis", Config{Rediser: client, BurstPeriod: time.Minute}, 10, false},
+		{"memory", Config{Store: NewMemoryStore(), BurstPeriod: time.Minute}, 10, false},
+		{"below an emission interval", Config{Store: NewMemoryStore(), BurstPeriod: time.Second}, 1, false},
+		{"with burst", Config{Store: NewMemoryStore(), BurstPeriod: time.Minute, Burst: 5}, 0, true},
+		{"over period", Config{Store: NewMemoryStore(), BurstPeriod: 2 * time.Hour}, 0, true},
+		{"negative", Config{Store: NewMemoryStore(), BurstPeriod: -time.Minute}, 0, true},
+		{"sliding window", Config{Store: NewMemoryStore(), BurstPeriod: time.Minute, Algorithm: SlidingWindowAlgorithm}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Max = 600
+			tt.config.Period = time.Hour
+			if tt.config.Algorithm == 0 {
+				tt.config.Algorithm = GCRAAlgorithm
+			}
+
+			m, err := NewWithConfigE(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			e := echo.New()
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error { return nil })
+
+			allowed := 0
+			for _, code := range codes(e, 15) {
+				if code == http.StatusOK {
+					allowed++
+				}
+			}
+
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    LimitRule
+		wantErr bool
+	}{
+		{"100/1m", LimitRule{Max: 100, Period: time.Minute}, false},
+		{"10/1s", LimitRule{Max: 10, Period: time.Second}, false},
+		{"1000/1h", LimitRule{Max: 1000, Period: time.Hour}, false},
+		{"100/m", LimitRule{Max: 100, Period: time.Minute}, false},
+		{" 5 / 500ms ", LimitRule{Max: 5, Period: 500 * time.Millisecond}, false},
+		{"100", LimitRule{}, true},
+		{"0/1m", LimitRule{}, true},
+		{"x/1m", LimitRule{}, true},
+		{"100/forever", LimitRule{}, true},
+		{"100/-1m", LimitRule{}, true},
+		{"1/2/3", LimitRule{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.s)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) = %+v, %v, want %+v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLimitRuleString(t *testing.T) {
+	tests := []struct {
+		rule LimitRule
+		want string
+	}{
+		{LimitRule{Max: 100, Period: time.Minute}, "100/1m"},
+		{LimitRule{Max: 10, Period: 90 * time.Second}, "10/1m30s"},
+		{LimitRule{Max: 1000, Period: time.Hour}, "1000/1h"},
+		{LimitRule{Max: 5, Period: 500 * time.Millisecond}, "5/500ms"},
+	}
+
+	for _, tt := range tests {
+		got := tt.rule.String()
+		if got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+
+		if parsed, err := ParseRate(got); err != nil || parsed != tt.rule {
+			t.Errorf("ParseRate(%q) = %+v, %v, want %+v", got, parsed, err, tt.rule)
+		}
+	}
+}
+
+func TestNewDefaultConfig(t *testing.T) {
+	defer func(whitelist []string) { DefaultConfig.Whitelist = whitelist }(DefaultConfig.Whitelist)
+	DefaultConfig.Whitelist = []string{"a"}
+
+	a, b := NewDefaultConfig(), NewDefaultConfig()
+	a.Whitelist[0] = "changed"
+
+	if b.Whitelist[0] != "a" || DefaultConfig.Whitelist[0] != "a" {
+		t.Errorf("whitelists = %v and %v, want the defaults unchanged", b.Whitelist, DefaultConfig.Whitelist)
+	}
+}
+
+func TestConfigIsolation(t *testing.T) {
+	config := Config{
+		Max:        1,
+		Period:     time.Minute,
+		Exclude:    []string{"/health"},
+		Escalation: []EscalationRule{{Violations: 1, Window: time.Minute, Ban: time.Hour}},
+	}
+
+	newLimited := func() *echo.Echo {
+		c := config
+		c.Store = NewMemoryStore()
+		return newServer(t, c)
+	}
+
+	first := newLimited()
+
+	// Mutating the caller's config must not reach the built middleware
+	config.Exclude[0] = "/other"
+	config.Escalation[0].Ban = time.Second
+
+	second := newLimited()
+
+	tests := []struct {
+		name string
+		e    *echo.Echo
+		path string
+		want []int
+	}{
+		{"first excluded", first, "/health", []int{http.StatusOK, http.StatusOK}},
+		{"first limited", first, "/other", []int{http.StatusOK, http.StatusTooManyRequests}},
+		{"second excluded", second, "/other", []int{http.StatusOK, http.StatusOK}},
+		{"second limited", second, "/health", []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]int, len(tt.want))
+			for i := range got {
+				got[i] = serve(tt.e, http.MethodGet, tt.path).Code
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if retryAfter, _ := strconv.Atoi(serve(first, http.MethodGet, "/other").Header().Get("Retry-After")); retryAfter < 3500 {
+		t.Errorf("Retry-After = %d, want the hour ban of the first config", retryAfter)
+	}
+}
@@ -0,0 +1,62 @@
+package echo_limiter
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderCost returns a Cost func reading the cost from header, e.g.
+// X-Request-Cost sent by internal callers, clamped to max. The header is
+// client supplied, so it's only read for requests whose peer is one of
+// trustedSources, IPs or CIDRs like "10.0.0.0/8", other requests, absent
+// and invalid values cost 1. It panics on an invalid trusted source
+func HeaderCost(header string, max int, trustedSources []string) func(echo.Context) int {
+	trusted := mustParseNets(trustedSources)
+
+	return func(ctx echo.Context) int {
+		if !contains(trusted, net.ParseIP(peerIP(ctx))) {
+			return 1
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(ctx.Request().Header.Get(header)))
+		if err != nil || n < 1 {
+			return 1
+		}
+
+		if n > max {
+			return max
+		}
+
+		return n
+	}
+}
+
+// capCost bounds the cost n of a request to 1 and the largest rate or
+// burst of buckets plus 1. A cost over every bucket is denied either way,
+// the cap keeps a huge one, e.g. from Content-Length, out of the store's
+// arithmetic
+func capCost(n int, buckets []bucket) int {
+	if n < 1 {
+		return 1
+	}
+
+	var max int64
+	for _, b := range buckets {
+		if b.limit.Rate > max {
+			max = b.limit.Rate
+		}
+
+		if b.limit.Burst > max {
+			max = b.limit.Burst
+		}
+	}
+
+	if int64(n) > max+1 {
+		return int(max + 1)
+	}
+
+	return n
+}
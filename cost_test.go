@@ -0,0 +1,99 @@
+package echo_limiter
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/echo_limiter/testutil"
+	"github.com/shareed2k/go_limiter"
+)
+
+func TestWeightByContentLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int64
+		want   int
+	}{
+		{"unknown", -1, 1},
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"one unit", 1024, 1},
+		{"rounded up", 1025, 2},
+		{"ten units", 10 * 1024, 10},
+		{"capped", math.MaxInt64, 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testutil.AllowAll()
+			e := newServer(t, Config{Store: store, Max: 100, WeightByContentLength: true})
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.ContentLength = tt.length
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			if calls := store.Calls(); len(calls) != 1 || calls[0].N != tt.want {
+				t.Errorf("calls = %+v, want a cost of %d", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapCost(t *testing.T) {
+	buckets := []bucket{
+		{limit: &go_limiter.Limit{Rate: 10, Burst: 20}},
+		{limit: &go_limiter.Limit{Rate: 50}},
+	}
+
+	tests := []struct {
+		n, want int
+	}{
+		{-5, 1},
+		{0, 1},
+		{1, 1},
+		{51, 51},
+		{52, 51},
+		{math.MaxInt32, 51},
+	}
+
+	for _, tt := range tests {
+		if got := capCost(tt.n, buckets); got != tt.want {
+			t.Errorf("capCost(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderCost(t *testing.T) {
+	cost := HeaderCost("X-Request-Cost", 10, []string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name  string
+		peer  string
+		value string
+		want  int
+	}{
+		{"valid", "10.0.0.1:1234", "5", 5},
+		{"spaces", "10.0.0.1:1234", " 3 ", 3},
+		{"oversized", "10.0.0.1:1234", "500", 10},
+		{"zero", "10.0.0.1:1234", "0", 1},
+		{"negative", "10.0.0.1:1234", "-4", 1},
+		{"invalid", "10.0.0.1:1234", "lots", 1},
+		{"absent", "10.0.0.1:1234", "", 1},
+		{"untrusted", "198.51.100.1:1234", "5", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			req.Header.Set("X-Request-Cost", tt.value)
+
+			if got := cost(echo.New().NewContext(req, httptest.NewRecorder())); got != tt.want {
+				t.Errorf("cost = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package echo_limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// deniedScript flags a key as denied for ttl milliseconds, refreshing
+	// the flag, and returns 1 when it wasn't flagged before
+	deniedScript = newScript(`
+local first = redis.call("EXISTS", KEYS[1]) == 0
+redis.call("SET", KEYS[1], "1", "PX", ARGV[1])
+return first and 1 or 0
+`)
+)
+
+type (
+	// deniedFlags remembers the keys currently denied, see OnFirstDenied
+	deniedFlags interface {
+		// mark flags key for ttl, reporting whether it wasn't flagged yet
+		mark(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	}
+
+	redisFlags struct {
+		scripter Scripter
+	}
+
+	memoryFlags struct {
+		mu    sync.Mutex
+		until map[string]time.Time
+		sweep time.Time
+	}
+)
+
+func (f *redisFlags) mark(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	v, err := deniedScript.run(ctx, f.scripter, []string{key}, ms)
+	if err != nil {
+		return false, err
+	}
+
+	return v.(int64) == 1, nil
+}
+
+func (f *memoryFlags) mark(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.expire(now)
+
+	until, ok := f.until[key]
+	f.until[key] = now.Add(ttl)
+
+	return !ok || !until.After(now), nil
+}
+
+// expire drops the flags gone stale, at most once a minute
+func (f *memoryFlags) expire(now time.Time) {
+	if now.Before(f.sweep) {
+		return
+	}
+
+	for key, until := range f.until {
+		if !until.After(now) {
+			delete(f.until, key)
+		}
+	}
+
+	f.sweep = now.Add(time.Minute)
+}
@@ -0,0 +1,80 @@
+package echo_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/go_limiter"
+)
+
+func TestOnFirstDenied(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"redis", Config{Rediser: client}},
+		{"memory", Config{Store: NewMemoryStore()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first, denied int
+
+			tt.config.Max = 1
+			tt.config.Period = time.Minute
+			tt.config.OnDenied = func(echo.Context, *go_limiter.Result) { denied++ }
+			tt.config.OnFirstDenied = func(echo.Context, *go_limiter.Result) { first++ }
+
+			codes(newServer(t, tt.config), 5)
+
+			if first != 1 || denied != 4 {
+				t.Errorf("first denied %d times, denied %d times, want 1 and 4", first, denied)
+			}
+		})
+	}
+}
+
+func TestDeniedFlags(t *testing.T) {
+	mr, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		flags  deniedFlags
+		elapse func(time.Duration)
+	}{
+		{"redis", &redisFlags{scripter: NewScripter(client)}, mr.FastForward},
+		{"memory", &memoryFlags{until: make(map[string]time.Time)}, time.Sleep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			steps := []struct {
+				key  string
+				ttl  time.Duration
+				want bool
+			}{
+				{"a", 50 * time.Millisecond, true},
+				{"a", 50 * time.Millisecond, false},
+				{"b", 50 * time.Millisecond, true},
+			}
+
+			for i, step := range steps {
+				if got, err := tt.flags.mark(ctx, step.key, step.ttl); err != nil || got != step.want {
+					t.Errorf("step %d: mark = %v (%v), want %v", i, got, err, step.want)
+				}
+			}
+
+			tt.elapse(50 * time.Millisecond)
+
+			if got, err := tt.flags.mark(ctx, "a", time.Minute); err != nil || !got {
+				t.Errorf("mark after the ttl = %v (%v), want true", got, err)
+			}
+		})
+	}
+}
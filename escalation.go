@@ -0,0 +1,219 @@
+package echo_limiter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// banScript returns the milliseconds left of a ban, below 1 when
+	// there is none
+	banScript = newScript(`return redis.call("PTTL", KEYS[1])`)
+
+	// violationScript counts a violation in a window of ARGV[1] ms and
+	// bans for ARGV[3] ms once there are ARGV[2], returning the ban in ms
+	// or 0
+	violationScript = newScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+
+if count < tonumber(ARGV[2]) then
+  return 0
+end
+
+redis.call("DEL", KEYS[1])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[3]) then
+  redis.call("SET", KEYS[2], "1", "PX", ARGV[3])
+end
+
+return tonumber(ARGV[3])
+`)
+
+	// unbanScript deletes the ban and violation keys of a key, they share
+	// its hash tag
+	unbanScript = newScript(`return redis.call("DEL", unpack(KEYS))`)
+)
+
+type (
+	// EscalationRule bans a key for Ban once it exceeded the limit
+	// Violations times within Window
+	EscalationRule struct {
+		Violations int
+		Window     time.Duration
+		Ban        time.Duration
+	}
+
+	// escalator tracks violations and bans, see Config.Escalation
+	escalator interface {
+		// banned returns what's left of the ban of key, 0 when there is none
+		banned(ctx context.Context, key string) (time.Duration, error)
+
+		// violate counts a violation of key, returning the ban it caused
+		violate(ctx context.Context, key string) (time.Duration, error)
+
+		// unban drops the ban and the violations of key
+		unban(ctx context.Context, key string) error
+	}
+
+	redisEscalator struct {
+		scripter  Scripter
+		prefix    string
+		separator string
+		rules     []EscalationRule
+	}
+
+	memoryEscalator struct {
+		rules []EscalationRule
+
+		mu         sync.Mutex
+		violations map[string]*violations
+		bans       map[string]time.Time
+		sweep      time.Time
+	}
+
+	violations struct {
+		count int
+		until time.Time
+	}
+)
+
+// banKey and violationsKey share the {key} hash tag so they land on the
+// same redis cluster slot
+func (e *redisEscalator) banKey(key string) string {
+	return e.prefix + e.separator + "ban" + e.separator + "{" + key + "}"
+}
+
+func (e *redisEscalator) violationsKey(rule EscalationRule, key string) string {
+	return e.prefix + e.separator + "violations" + e.separator + rule.id(e.separator) + e.separator + "{" + key + "}"
+}
+
+// id tells the violation counters of rules apart, rules sharing a Window
+// count their violations on their own
+func (rule EscalationRule) id(separator string) string {
+	return strconv.Itoa(rule.Violations) + separator + rule.Window.String() + separator + rule.Ban.String()
+}
+
+func (e *redisEscalator) banned(ctx context.Context, key string) (time.Duration, error) {
+	v, err := banScript.run(ctx, e.scripter, []string{e.banKey(key)})
+	if err != nil {
+		return 0, err
+	}
+
+	if ms := v.(int64); ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	return 0, nil
+}
+
+func (e *redisEscalator) violate(ctx context.Context, key string) (time.Duration, error) {
+	var ban time.Duration
+	for _, rule := range e.rules {
+		v, err := violationScript.run(ctx, e.scripter, []string{e.violationsKey(rule, key), e.banKey(key)},
+			rule.Window.Milliseconds(), rule.Violations, rule.Ban.Milliseconds())
+		if err != nil {
+			return 0, err
+		}
+
+		if d := time.Duration(v.(int64)) * time.Millisecond; d > ban {
+			ban = d
+		}
+	}
+
+	return ban, nil
+}
+
+func (e *redisEscalator) unban(ctx context.Context, key string) error {
+	keys := []string{e.banKey(key)}
+	for _, rule := range e.rules {
+		keys = append(keys, e.violationsKey(rule, key))
+	}
+
+	_, err := unbanScript.run(ctx, e.scripter, keys)
+	return err
+}
+
+func (e *memoryEscalator) banned(_ context.Context, key string) (time.Duration, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if left := time.Until(e.bans[key]); left > 0 {
+		return left, nil
+	}
+
+	delete(e.bans, key)
+
+	return 0, nil
+}
+
+func (e *memoryEscalator) violate(_ context.Context, key string) (time.Duration, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	e.expire(now)
+
+	var ban time.Duration
+	for _, rule := range e.rules {
+		k := rule.id(defaultKeySeparator) + defaultKeySeparator + key
+
+		v, ok := e.violations[k]
+		if !ok || !v.until.After(now) {
+			v = &violations{until: now.Add(rule.Window)}
+			e.violations[k] = v
+		}
+
+		v.count++
+		if v.count < rule.Violations {
+			continue
+		}
+
+		delete(e.violations, k)
+		if until := now.Add(rule.Ban); until.After(e.bans[key]) {
+			e.bans[key] = until
+		}
+
+		if rule.Ban > ban {
+			ban = rule.Ban
+		}
+	}
+
+	return ban, nil
+}
+
+func (e *memoryEscalator) unban(_ context.Context, key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.bans, key)
+	for _, rule := range e.rules {
+		delete(e.violations, rule.id(defaultKeySeparator)+defaultKeySeparator+key)
+	}
+
+	return nil
+}
+
+// expire drops the violations and bans gone stale, at most once a minute
+func (e *memoryEscalator) expire(now time.Time) {
+	if now.Before(e.sweep) {
+		return
+	}
+
+	for key, v := range e.violations {
+		if !v.until.After(now) {
+			delete(e.violations, key)
+		}
+	}
+
+	for key, until := range e.bans {
+		if !until.After(now) {
+			delete(e.bans, key)
+		}
+	}
+
+	e.sweep = now.Add(time.Minute)
+}
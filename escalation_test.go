@@ -0,0 +1,172 @@
+package echo_limiter
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// escalationRules ban for an hour after two violations within a minute
+var escalationRules = []EscalationRule{{Violations: 2, Window: time.Minute, Ban: time.Hour}}
+
+func TestEscalators(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name string
+		esc  escalator
+	}{
+		{"redis", &redisEscalator{scripter: NewScripter(client), prefix: DefaultKeyPrefix, separator: defaultKeySeparator, rules: escalationRules}},
+		{"memory", &memoryEscalator{rules: escalationRules, violations: make(map[string]*violations), bans: make(map[string]time.Time)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			steps := []struct {
+				name string
+				f    func() (time.Duration, error)
+				ban  bool
+			}{
+				{"first violation", func() (time.Duration, error) { return tt.esc.violate(ctx, "k") }, false},
+				{"not banned", func() (time.Duration, error) { return tt.esc.banned(ctx, "k") }, false},
+				{"second violation", func() (time.Duration, error) { return tt.esc.violate(ctx, "k") }, true},
+				{"banned", func() (time.Duration, error) { return tt.esc.banned(ctx, "k") }, true},
+				{"other key", func() (time.Duration, error) { return tt.esc.banned(ctx, "other") }, false},
+				{"unbanned", func() (time.Duration, error) { return 0, tt.esc.unban(ctx, "k") }, false},
+				{"not banned after unban", func() (time.Duration, error) { return tt.esc.banned(ctx, "k") }, false},
+				{"violations dropped", func() (time.Duration, error) { return tt.esc.violate(ctx, "k") }, false},
+			}
+
+			for _, step := range steps {
+				d, err := step.f()
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+
+				if banned := d > time.Hour-time.Minute; banned != step.ban {
+					t.Errorf("%s: ban = %v, want banned %v", step.name, d, step.ban)
+				}
+			}
+		})
+	}
+}
+
+func TestEscalatorsSharedWindow(t *testing.T) {
+	_, client := newRedis(t)
+
+	rules := []EscalationRule{
+		{Violations: 3, Window: time.Minute, Ban: time.Minute},
+		{Violations: 5, Window: time.Minute, Ban: time.Hour},
+	}
+
+	tests := []struct {
+		name string
+		esc  escalator
+	}{
+		{"redis", &redisEscalator{scripter: NewScripter(client), prefix: DefaultKeyPrefix, separator: defaultKeySeparator, rules: rules}},
+		{"memory", &memoryEscalator{rules: rules, violations: make(map[string]*violations), bans: make(map[string]time.Time)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Every rule counts each violation once on its own counter
+			want := []time.Duration{0, 0, time.Minute, 0, time.Hour, time.Minute}
+			got := make([]time.Duration, len(want))
+			for i := range got {
+				d, err := tt.esc.violate(ctx, "k")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[i] = d
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("bans = %v, want %v", got, want)
+			}
+
+			if err := tt.esc.unban(ctx, "k"); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if d, err := tt.esc.violate(ctx, "k"); err != nil || d != 0 {
+					t.Errorf("violation %d after unban = %v (%v), want no ban", i+1, d, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResetSharedWindow(t *testing.T) {
+	_, client := newRedis(t)
+
+	rules := []EscalationRule{
+		{Violations: 2, Window: time.Minute, Ban: time.Minute},
+		{Violations: 3, Window: time.Minute, Ban: time.Hour},
+	}
+	esc := &redisEscalator{scripter: NewScripter(client), prefix: "reset", separator: defaultKeySeparator, rules: rules}
+	ctx := context.Background()
+
+	if _, err := esc.violate(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Reset(client, "reset", "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := client.Keys("reset:*").Val(); len(keys) != 0 {
+		t.Errorf("keys after Reset = %v, want none", keys)
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+		reset  func(l *Limiter) error
+	}{
+		{"redis", Config{Rediser: client, Prefix: "limiter"}, func(l *Limiter) error { return l.Reset("192.0.2.1") }},
+		{"redis package reset", Config{Rediser: client, Prefix: "package"}, func(*Limiter) error { return Reset(client, "package", "192.0.2.1") }},
+		{"memory", Config{Store: NewMemoryStore()}, func(l *Limiter) error { return l.Reset("192.0.2.1") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Max = 1
+			tt.config.Period = 100 * time.Millisecond
+			tt.config.Escalation = escalationRules
+			e, l := newBuilt(t, tt.config)
+
+			want := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+			if got := codes(e, 3); !reflect.DeepEqual(got, want) {
+				t.Fatalf("codes = %v, want %v", got, want)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+
+			rec := serve(e, http.MethodGet, "/")
+			if retryAfter, _ := strconv.Atoi(rec.Header().Get("Retry-After")); rec.Code != http.StatusTooManyRequests || retryAfter < 3500 {
+				t.Errorf("code %d Retry-After %d after the period, want a ban", rec.Code, retryAfter)
+			}
+
+			if err := tt.reset(l); err != nil {
+				t.Fatal(err)
+			}
+
+			want = []int{http.StatusOK, http.StatusTooManyRequests}
+			if got := codes(e, 2); !reflect.DeepEqual(got, want) {
+				t.Errorf("codes after reset = %v, want %v", got, want)
+			}
+		})
+	}
+}
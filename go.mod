@@ -3,8 +3,13 @@ module github.com/shareed2k/echo_limiter
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.11.4
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-redis/redis/v7 v7.3.0
+	github.com/go-redis/redis/v8 v8.4.4
 	github.com/labstack/echo/v4 v4.1.16
+	github.com/labstack/gommon v0.3.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/shareed2k/go_limiter v0.0.7
-	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59 // indirect
+	go.opentelemetry.io/otel v0.15.0
 )
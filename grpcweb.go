@@ -0,0 +1,78 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MetadataKey returns a Key func limiting by the metadata name, hashed like
+// for HashedHeaderKey, read from the request headers in any case, e.g.
+// "x-user-id" of gRPC or HTTP/2 clients. A request announcing name in its
+// Trailer header gets its first max bytes of body read, and put back like
+// for BodyKey, to find the trailer, net/http only fills the trailers once
+// the body was read to the end. Requests without it, or with a longer body,
+// are keyed by ctx.RealIP()
+func MetadataKey(name string, max int64) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		req := ctx.Request()
+		if v := headerValue(req.Header, name); v != "" {
+			return hash(v)
+		}
+
+		if _, ok := req.Trailer[http.CanonicalHeaderKey(name)]; !ok || req.Body == nil || req.Body == http.NoBody {
+			return ctx.RealIP()
+		}
+
+		// One byte past max tells a body of exactly max bytes, read to the
+		// end with its trailers, from a longer one
+		if body, err := peekBody(req, max+1); err != nil || int64(len(body)) > max {
+			return ctx.RealIP()
+		}
+
+		if v := headerValue(req.Trailer, name); v != "" {
+			return hash(v)
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// GRPCWebKey returns a Key func limiting gRPC-Web calls, a Content-Type of
+// application/grpc-web or application/grpc-web-text, by the metadata name
+// like for MetadataKey. gRPC-Web clients send the call metadata as plain
+// request headers, their trailers only flow back to the client. Other
+// requests are keyed by ctx.RealIP()
+func GRPCWebKey(name string) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		h := ctx.Request().Header
+		if !strings.HasPrefix(h.Get(echo.HeaderContentType), "application/grpc-web") {
+			return ctx.RealIP()
+		}
+
+		if v := headerValue(h, name); v != "" {
+			return hash(v)
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// headerValue returns the first value of name in h. net/http canonicalizes
+// the names it parses, HTTP/2 lower case ones included, the case
+// insensitive scan finds the ones set into the map directly, e.g. by a
+// proxy or a test
+func headerValue(h http.Header, name string) string {
+	if v := h.Get(name); v != "" {
+		return v
+	}
+
+	for k, values := range h {
+		if strings.EqualFold(k, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
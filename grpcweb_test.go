@@ -0,0 +1,114 @@
+package echo_limiter
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMetadataKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"canonical", http.Header{"X-User-Id": {"alice"}}, hash("alice")},
+		{"lower case", http.Header{"x-user-id": {"alice"}}, hash("alice")},
+		{"missing", http.Header{"X-Other": {"alice"}}, "192.0.2.1"},
+		{"empty", http.Header{"X-User-Id": {""}}, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header = tt.header
+			ctx := echo.New().NewContext(req, httptest.NewRecorder())
+
+			if got := MetadataKey("x-user-id", 1024)(ctx); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataKeyTrailer(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		max  int64
+		want string
+	}{
+		{"trailer", "payload", 1024, hash("alice")},
+		{"exactly the cap", "payload", 7, hash("alice")},
+		{"one byte over the cap", "payload", 6, "127.0.0.1"},
+		{"over the cap", "payload", 3, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := echo.New().NewContext(r, w)
+				key = MetadataKey("x-user-id", tt.max)(ctx)
+
+				b, _ := ioutil.ReadAll(ctx.Request().Body)
+				body = string(b)
+			}))
+			defer srv.Close()
+
+			// A pipe has no known length, so the client sends the body
+			// chunked with the trailer after it. The pause keeps the end of
+			// the body out of the first read of the server
+			r, w := io.Pipe()
+			go func() {
+				w.Write([]byte(tt.body))
+				time.Sleep(20 * time.Millisecond)
+				w.Close()
+			}()
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Trailer = http.Header{"X-User-Id": {"alice"}}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if key != tt.want || body != tt.body {
+				t.Errorf("key %q body %q, want %q %q", key, body, tt.want, tt.body)
+			}
+		})
+	}
+}
+
+func TestGRPCWebKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		user        string
+		want        string
+	}{
+		{"grpc-web", "application/grpc-web+proto", "alice", hash("alice")},
+		{"grpc-web-text", "application/grpc-web-text", "alice", hash("alice")},
+		{"no metadata", "application/grpc-web", "", "192.0.2.1"},
+		{"plain http", echo.MIMEApplicationJSON, "alice", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keyOf(GRPCWebKey("x-user-id"), "192.0.2.1:1234", echo.HeaderContentType, tt.contentType, "x-user-id", tt.user)
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package echo_limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// seenScript reports whether KEYS[1] is flagged and was replayed at
+	// most ARGV[1] times, counting this replay. INCR keeps the expiry
+	seenScript = newScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+  return 0
+end
+
+if redis.call("INCR", KEYS[1]) > tonumber(ARGV[1]) then
+  return 0
+end
+
+return 1
+`)
+
+	// seeScript flags KEYS[1] for ARGV[1] milliseconds, keeping the expiry
+	// and the replays of a flag set already
+	seeScript = newScript(`
+redis.call("SET", KEYS[1], "0", "NX", "PX", ARGV[1])
+return 0
+`)
+)
+
+type (
+	// idempotency remembers the idempotency keys of requests that took
+	// their tokens, see IdempotencyHeader
+	idempotency interface {
+		// seen reports whether key is flagged and was replayed at most
+		// max times, counting this replay
+		seen(ctx context.Context, key string, max int) (bool, error)
+
+		// see flags key for ttl
+		see(ctx context.Context, key string, ttl time.Duration) error
+	}
+
+	redisIdempotency struct {
+		scripter Scripter
+	}
+
+	memoryIdempotency struct {
+		mu    sync.Mutex
+		flags map[string]*seenFlag
+		sweep time.Time
+	}
+
+	seenFlag struct {
+		until   time.Time
+		replays int
+	}
+)
+
+func (i *redisIdempotency) seen(ctx context.Context, key string, max int) (bool, error) {
+	v, err := seenScript.run(ctx, i.scripter, []string{key}, max)
+	if err != nil {
+		return false, err
+	}
+
+	return v.(int64) == 1, nil
+}
+
+func (i *redisIdempotency) see(ctx context.Context, key string, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	_, err := seeScript.run(ctx, i.scripter, []string{key}, ms)
+	return err
+}
+
+func (i *memoryIdempotency) seen(_ context.Context, key string, max int) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	f, ok := i.flags[key]
+	if !ok || !f.until.After(time.Now()) {
+		return false, nil
+	}
+
+	f.replays++
+
+	return f.replays <= max, nil
+}
+
+func (i *memoryIdempotency) see(_ context.Context, key string, ttl time.Duration) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	i.expire(now)
+
+	if f, ok := i.flags[key]; !ok || !f.until.After(now) {
+		i.flags[key] = &seenFlag{until: now.Add(ttl)}
+	}
+
+	return nil
+}
+
+// expire drops the flags gone stale, at most once a minute
+func (i *memoryIdempotency) expire(now time.Time) {
+	if now.Before(i.sweep) {
+		return
+	}
+
+	for key, f := range i.flags {
+		if !f.until.After(now) {
+			delete(i.flags, key)
+		}
+	}
+
+	i.sweep = now.Add(time.Minute)
+}
@@ -0,0 +1,163 @@
+package echo_limiter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIdempotencies(t *testing.T) {
+	mr, client := newRedis(t)
+
+	// wait moves the clock of the idempotency on, miniredis only expires
+	// keys when fast forwarded
+	tests := []struct {
+		name string
+		idem idempotency
+		wait func(time.Duration)
+	}{
+		{"redis", &redisIdempotency{scripter: NewScripter(client)}, mr.FastForward},
+		{"memory", &memoryIdempotency{flags: make(map[string]*seenFlag)}, time.Sleep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if seen, err := tt.idem.seen(ctx, "k", 2); err != nil || seen {
+				t.Fatalf("seen before see = %v (%v), want false", seen, err)
+			}
+
+			if err := tt.idem.see(ctx, "k", 100*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			// A second see keeps the first expiry and the replays
+			tt.wait(60 * time.Millisecond)
+			if err := tt.idem.see(ctx, "k", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []bool{true, true, false}
+			for i, w := range want {
+				if seen, err := tt.idem.seen(ctx, "k", 2); err != nil || seen != w {
+					t.Errorf("replay %d seen = %v (%v), want %v", i+1, seen, err, w)
+				}
+			}
+
+			if seen, err := tt.idem.seen(ctx, "other", 2); err != nil || seen {
+				t.Errorf("other seen = %v (%v), want false", seen, err)
+			}
+
+			if err := tt.idem.see(ctx, "fresh", 100*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.wait(110 * time.Millisecond)
+			if seen, err := tt.idem.seen(ctx, "fresh", 2); err != nil || seen {
+				t.Errorf("seen after the window = %v (%v), want false", seen, err)
+			}
+		})
+	}
+}
+
+func TestIdempotencyHeader(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"redis", Config{Rediser: client}},
+		{"memory", Config{Store: NewMemoryStore()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Max = 3
+			tt.config.Period = time.Minute
+			tt.config.IdempotencyHeader = "Idempotency-Key"
+			tt.config.IdempotencyMaxReplays = 2
+			e := newServer(t, tt.config)
+
+			steps := []struct {
+				name    string
+				headers []string
+				want    int
+			}{
+				{"first", []string{"Idempotency-Key", "a"}, http.StatusOK},
+				{"replay", []string{"Idempotency-Key", "a"}, http.StatusOK},
+				{"second replay", []string{"Idempotency-Key", "a"}, http.StatusOK},
+				{"over max replays", []string{"Idempotency-Key", "a"}, http.StatusOK},
+				{"other value", []string{"Idempotency-Key", "b"}, http.StatusOK},
+				{"over max replays denied", []string{"Idempotency-Key", "a"}, http.StatusTooManyRequests},
+				{"denied", []string{"Idempotency-Key", "c"}, http.StatusTooManyRequests},
+				{"denied not seen", []string{"Idempotency-Key", "c"}, http.StatusTooManyRequests},
+				{"replay without tokens left", []string{"Idempotency-Key", "b"}, http.StatusTooManyRequests},
+				// Values are scoped to the key
+				{"other key", []string{"Idempotency-Key", "a", echo.HeaderXRealIP, "192.0.2.2"}, http.StatusOK},
+			}
+
+			for _, step := range steps {
+				if got := serve(e, http.MethodGet, "/", step.headers...).Code; got != step.want {
+					t.Errorf("%s: code = %d, want %d", step.name, got, step.want)
+				}
+			}
+
+			rec := serve(e, http.MethodGet, "/", "Idempotency-Key", "d", echo.HeaderXRealIP, "192.0.2.2")
+			if rec.Code != http.StatusOK || rec.Header().Get(defaultRemainingHeader) != "1" {
+				t.Errorf("code %d remaining %q for the other key, want %d 1", rec.Code, rec.Header().Get(defaultRemainingHeader), http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestIdempotencyReplayDenied(t *testing.T) {
+	_, client := newRedis(t)
+
+	escalation := []EscalationRule{{Violations: 1, Window: time.Minute, Ban: time.Hour}}
+
+	// ban gets the key banned and lets its limit refill, so only the ban
+	// denies the replay
+	ban := func(e *echo.Echo) {
+		serve(e, http.MethodGet, "/", "Idempotency-Key", "other")
+		time.Sleep(110 * time.Millisecond)
+	}
+
+	// exhaustGlobal takes the last GlobalMax token with another key
+	exhaustGlobal := func(e *echo.Echo) {
+		serve(e, http.MethodGet, "/", echo.HeaderXRealIP, "192.0.2.2")
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		before func(*echo.Echo)
+	}{
+		{"banned", Config{Store: NewMemoryStore(), Max: 1, Period: 100 * time.Millisecond, Escalation: escalation}, ban},
+		{"banned redis", Config{Rediser: client, Prefix: "banned", Max: 1, Period: 100 * time.Millisecond, Escalation: escalation}, ban},
+		{"global max", Config{Store: NewMemoryStore(), Max: 10, Period: time.Minute, GlobalMax: 1}, exhaustGlobal},
+		{"global max redis", Config{Rediser: client, Prefix: "global", Max: 10, Period: time.Minute, GlobalMax: 1}, exhaustGlobal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.IdempotencyHeader = "Idempotency-Key"
+			tt.config.IdempotencyWindow = time.Minute
+			e := newServer(t, tt.config)
+
+			if got := serve(e, http.MethodGet, "/", "Idempotency-Key", "a").Code; got != http.StatusOK {
+				t.Fatalf("first code = %d, want %d", got, http.StatusOK)
+			}
+
+			tt.before(e)
+
+			if got := serve(e, http.MethodGet, "/", "Idempotency-Key", "a").Code; got != http.StatusTooManyRequests {
+				t.Errorf("replay code = %d, want %d", got, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
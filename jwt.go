@@ -0,0 +1,47 @@
+package echo_limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+// JWTClaimKey returns a Key func limiting by claim of the token echo's JWT
+// middleware stores under "user", e.g. "sub" for a limit per user. The JWT
+// middleware has to run before the limiter, e.Use(middleware.JWT(key))
+// first, requests without a token or the claim are keyed by ctx.RealIP()
+func JWTClaimKey(claim string) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		token, ok := ctx.Get("user").(*jwt.Token)
+		if !ok || token == nil {
+			return ctx.RealIP()
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			// Custom claims, read them by their json names
+			b, err := json.Marshal(token.Claims)
+			if err != nil || json.Unmarshal(b, &claims) != nil {
+				return ctx.RealIP()
+			}
+		}
+
+		switch v := claims[claim].(type) {
+		case nil:
+			return ctx.RealIP()
+		case string:
+			if v == "" {
+				return ctx.RealIP()
+			}
+
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
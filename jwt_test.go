@@ -0,0 +1,39 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+func TestJWTClaimKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  interface{}
+		claim string
+		want  string
+	}{
+		{"sub", &jwt.Token{Claims: jwt.MapClaims{"sub": "alice"}}, "sub", "alice"},
+		{"number", &jwt.Token{Claims: jwt.MapClaims{"uid": float64(42)}}, "uid", "42"},
+		{"bool", &jwt.Token{Claims: jwt.MapClaims{"admin": true}}, "admin", "true"},
+		{"custom claims", &jwt.Token{Claims: &jwt.StandardClaims{Subject: "bob"}}, "sub", "bob"},
+		{"empty claim", &jwt.Token{Claims: jwt.MapClaims{"sub": ""}}, "sub", "192.0.2.1"},
+		{"missing claim", &jwt.Token{Claims: jwt.MapClaims{}}, "sub", "192.0.2.1"},
+		{"no token", nil, "sub", "192.0.2.1"},
+		{"not a token", "alice", "sub", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+			ctx.Set("user", tt.user)
+
+			if got := JWTClaimKey(tt.claim)(ctx); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
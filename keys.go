@@ -0,0 +1,287 @@
+package echo_limiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SubnetKey returns a Key func limiting the client's network instead of
+// its single IP, v4Bits and v6Bits are the prefix lengths kept, e.g.
+// SubnetKey(32, 64) collapses every IPv6 /64 into a single bucket. The
+// key is the masked network, e.g. "2001:db8:1:2::/64"
+func SubnetKey(v4Bits, v6Bits int) func(echo.Context) string {
+	v4Mask := net.CIDRMask(clamp(v4Bits, 0, 8*net.IPv4len), 8*net.IPv4len)
+	v6Mask := net.CIDRMask(clamp(v6Bits, 0, 8*net.IPv6len), 8*net.IPv6len)
+
+	return func(ctx echo.Context) string {
+		return maskIP(ctx.RealIP(), v4Mask, v6Mask)
+	}
+}
+
+// HeaderIPKey returns a Key func reading the client IP from header, e.g.
+// CF-Connecting-IP, but only when the request comes from one of the
+// trustedProxies, IPs or CIDRs like "10.0.0.0/8". For a list like
+// X-Forwarded-For the rightmost entry, the one the proxy added, is used.
+// A request from any other peer is keyed by the peer IP so the header
+// can't be spoofed, a trusted proxy without a valid header falls back to
+// the peer IP too, ctx.RealIP() would read the client supplied
+// X-Forwarded-For. It panics on an invalid trusted proxy
+func HeaderIPKey(header string, trustedProxies []string) func(echo.Context) string {
+	trusted := mustParseNets(trustedProxies)
+
+	return func(ctx echo.Context) string {
+		peer := peerIP(ctx)
+		if !contains(trusted, net.ParseIP(peer)) {
+			return peer
+		}
+
+		values := strings.Split(ctx.Request().Header.Get(header), ",")
+		if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+			return ip.String()
+		}
+
+		return peer
+	}
+}
+
+// ForwardedForKey returns a Key func finding the client IP in
+// X-Forwarded-For independently of echo's IPExtractor. The peer and the
+// list are walked right to left skipping trustedProxies, IPs or CIDRs like
+// "10.0.0.0/8", the first untrusted address is the client. Addresses left
+// of it were supplied by the client and are never trusted, an invalid
+// entry stops the walk at the last trusted hop. It panics on an invalid
+// trusted proxy
+func ForwardedForKey(trustedProxies []string) func(echo.Context) string {
+	trusted := mustParseNets(trustedProxies)
+
+	return func(ctx echo.Context) string {
+		client := peerIP(ctx)
+		if !contains(trusted, net.ParseIP(client)) {
+			return client
+		}
+
+		hops := strings.Split(strings.Join(ctx.Request().Header.Values(echo.HeaderXForwardedFor), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+
+			client = ip.String()
+			if !contains(trusted, ip) {
+				break
+			}
+		}
+
+		return client
+	}
+}
+
+// FingerprintKey returns a Key func combining the client IP from ip,
+// ctx.RealIP() when nil, with a stable client fingerprint header, e.g. a
+// device id, so users behind a shared NAT get buckets of their own. The
+// key is "<ip><separator><hash of the header>", or the IP alone when the
+// header is absent, pass the Config.KeySeparator of the middleware, ":"
+// when empty. The fingerprint is client supplied, so a client can rotate
+// it for fresh buckets, add a looser middleware keyed by IP alone to cap
+// that
+func FingerprintKey(ip func(echo.Context) string, header, separator string) func(echo.Context) string {
+	if ip == nil {
+		ip = DefaultConfig.Key
+	}
+
+	if separator == "" {
+		separator = defaultKeySeparator
+	}
+
+	return func(ctx echo.Context) string {
+		key := ip(ctx)
+
+		fingerprint := headerValue(ctx.Request().Header, header)
+		if fingerprint == "" {
+			return key
+		}
+
+		return key + separator + hash(fingerprint)
+	}
+}
+
+// HashedHeaderKey returns a Key func limiting by the value of header, e.g.
+// X-API-Key, hashed with SHA-256 so redis never stores the plain secret.
+// Requests without the header are keyed by ctx.RealIP()
+func HashedHeaderKey(header string) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		if v := headerValue(ctx.Request().Header, header); v != "" {
+			return hash(v)
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// CookieKey returns a Key func limiting by the value of the cookie name,
+// e.g. a session cookie, hashed like for HashedHeaderKey so redis never
+// stores session ids. Requests without the cookie are keyed by
+// ctx.RealIP()
+func CookieKey(name string) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		if c, err := ctx.Cookie(name); err == nil && c.Value != "" {
+			return hash(c.Value)
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// hash returns the first 128 bits of the SHA-256 of s in hex
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// TenantKey returns a Key func limiting by the tenant subdomain of domain,
+// e.g. "acme" for acme.example.com and api.acme.example.com with domain
+// "example.com", so all users of a tenant share its quota. Requests to
+// another host or an invalid one are keyed by ctx.RealIP()
+func TenantKey(domain string) func(echo.Context) string {
+	suffix := "." + strings.ToLower(strings.Trim(domain, "."))
+
+	return func(ctx echo.Context) string {
+		host := ctx.Request().Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if !strings.HasSuffix(host, suffix) {
+			return ctx.RealIP()
+		}
+
+		labels := strings.Split(strings.TrimSuffix(host, suffix), ".")
+		if tenant := labels[len(labels)-1]; validLabel(tenant) {
+			return tenant
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// PathTenantKey returns a Key func limiting by the segment of the request
+// path at index, e.g. "acme" for /tenants/acme/users with index 1. A
+// missing or invalid segment is keyed by ctx.RealIP()
+func PathTenantKey(index int) func(echo.Context) string {
+	return func(ctx echo.Context) string {
+		segments := strings.Split(strings.Trim(ctx.Request().URL.Path, "/"), "/")
+		if index >= 0 && index < len(segments) && validLabel(strings.ToLower(segments[index])) {
+			return strings.ToLower(segments[index])
+		}
+
+		return ctx.RealIP()
+	}
+}
+
+// validLabel reports whether s is a valid lower case DNS label
+func validLabel(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// peerIP is the IP of the immediate peer of the request
+func peerIP(ctx echo.Context) string {
+	addr := ctx.Request().RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// mustParseNets parses IPs and CIDRs, single IPs become a /32 or /128
+func mustParseNets(values []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				panic(fmt.Errorf("invalid trusted proxy %q", v))
+			}
+
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			panic(fmt.Errorf("invalid trusted proxy %q: %w", v, err))
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maskIP masks s with the mask of its family, s is returned as is when
+// it isn't an IP
+func maskIP(s string, v4Mask, v6Mask net.IPMask) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+
+	mask := v6Mask
+	if v4 := ip.To4(); v4 != nil {
+		ip, mask = v4, v4Mask
+	}
+
+	ones, _ := mask.Size()
+
+	return ip.Mask(mask).String() + "/" + strconv.Itoa(ones)
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+
+	if v > max {
+		return max
+	}
+
+	return v
+}
@@ -0,0 +1,234 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// keyOf returns the key of a request from remoteAddr, headers are name
+// and value pairs
+func keyOf(key func(echo.Context) string, remoteAddr string, headers ...string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+
+	return key(echo.New().NewContext(req, httptest.NewRecorder()))
+}
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		v4, v6 int
+		ip     string
+		want   string
+	}{
+		{"v4 host", 32, 64, "192.0.2.1", "192.0.2.1/32"},
+		{"v4 /24", 24, 64, "192.0.2.77", "192.0.2.0/24"},
+		{"v6 /64", 32, 64, "2001:db8:1:2:aaaa::1", "2001:db8:1:2::/64"},
+		{"v6 /48", 32, 48, "2001:db8:1:2::1", "2001:db8:1::/48"},
+		{"clamped", 99, -1, "2001:db8::1", "::/0"},
+		{"not an ip", 24, 64, "unknown", "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyOf(SubnetKey(tt.v4, tt.v6), "192.0.2.1:1234", echo.HeaderXRealIP, tt.ip); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderIPKey(t *testing.T) {
+	key := HeaderIPKey("CF-Connecting-IP", []string{"10.0.0.0/8", "192.0.2.1"})
+
+	tests := []struct {
+		name   string
+		peer   string
+		header string
+		want   string
+	}{
+		{"trusted cidr", "10.1.2.3:1234", "203.0.113.5", "203.0.113.5"},
+		{"trusted ip", "192.0.2.1:1234", "203.0.113.5", "203.0.113.5"},
+		{"rightmost entry", "10.1.2.3:1234", "198.51.100.1, 203.0.113.5", "203.0.113.5"},
+		{"spoofed", "198.51.100.9:1234", "203.0.113.5", "198.51.100.9"},
+		{"missing header", "10.1.2.3:1234", "", "10.1.2.3"},
+		{"invalid header", "10.1.2.3:1234", "not-an-ip", "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyOf(key, tt.peer, "CF-Connecting-IP", tt.header, echo.HeaderXForwardedFor, "1.1.1.1"); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderIPKeyPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on an invalid trusted proxy")
+		}
+	}()
+
+	HeaderIPKey("CF-Connecting-IP", []string{"10.0.0.0/99"})
+}
+
+func TestFingerprintKey(t *testing.T) {
+	device := hash("device-1")
+
+	tests := []struct {
+		name        string
+		ip          func(echo.Context) string
+		separator   string
+		fingerprint string
+		want        string
+	}{
+		{"real ip", nil, "", "device-1", "192.0.2.1:" + device},
+		{"custom ip", func(echo.Context) string { return "10.0.0.1" }, "", "device-1", "10.0.0.1:" + device},
+		{"other device", nil, "", "device-2", "192.0.2.1:" + hash("device-2")},
+		{"no fingerprint", nil, "", "", "192.0.2.1"},
+		{"custom separator", nil, "|", "device-1", "192.0.2.1|" + device},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyOf(FingerprintKey(tt.ip, "X-Device-Id", tt.separator), "192.0.2.1:1234", "X-Device-Id", tt.fingerprint); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantKey(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"acme.example.com", "acme"},
+		{"api.acme.example.com", "acme"},
+		{"ACME.Example.com:8080", "acme"},
+		{"acme.example.com.", "acme"},
+		{"example.com", "192.0.2.1"},
+		{"acme.other.com", "192.0.2.1"},
+		{"bad_tenant.example.com", "192.0.2.1"},
+	}
+
+	key := TenantKey(".example.com")
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tt.host
+
+		if got := key(echo.New().NewContext(req, httptest.NewRecorder())); got != tt.want {
+			t.Errorf("host %q: key = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestPathTenantKey(t *testing.T) {
+	tests := []struct {
+		path  string
+		index int
+		want  string
+	}{
+		{"/tenants/acme/users", 1, "acme"},
+		{"/tenants/ACME/users", 1, "acme"},
+		{"/tenants/acme/users", 0, "tenants"},
+		{"/tenants", 1, "192.0.2.1"},
+		{"/tenants/a.b/users", 1, "192.0.2.1"},
+		{"/tenants/acme", -1, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+		if got := PathTenantKey(tt.index)(echo.New().NewContext(req, httptest.NewRecorder())); got != tt.want {
+			t.Errorf("%s at %d: key = %q, want %q", tt.path, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestHashedHeaderKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"hashed", "secret-key", hash("secret-key")},
+		{"missing", "", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keyOf(HashedHeaderKey("X-API-Key"), "192.0.2.1:1234", "X-API-Key", tt.value)
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+
+			if tt.value != "" && (len(got) != 32 || strings.Contains(got, tt.value)) {
+				t.Errorf("key %q, want 32 hex digits without the secret", got)
+			}
+		})
+	}
+}
+
+func TestForwardedForKey(t *testing.T) {
+	key := ForwardedForKey([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers []string
+		want    string
+	}{
+		{"untrusted peer", "198.51.100.9:1234", []string{"203.0.113.5"}, "198.51.100.9"},
+		{"one proxy", "10.0.0.1:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"proxy chain", "10.0.0.1:1234", []string{"203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"spoofed left", "10.0.0.1:1234", []string{"1.1.1.1, 203.0.113.5"}, "203.0.113.5"},
+		{"several headers", "10.0.0.1:1234", []string{"203.0.113.5", "10.0.0.2"}, "203.0.113.5"},
+		{"invalid entry", "10.0.0.1:1234", []string{"garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			for _, v := range tt.headers {
+				headers = append(headers, echo.HeaderXForwardedFor, v)
+			}
+
+			if got := keyOf(key, tt.peer, headers...); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"session", "session=abc123", hash("abc123")},
+		{"other cookie", "theme=dark", "192.0.2.1"},
+		{"empty", "session=", "192.0.2.1"},
+		{"none", "", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyOf(CookieKey("session"), "192.0.2.1:1234", "Cookie", tt.cookie); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
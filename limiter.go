@@ -0,0 +1,137 @@
+package echo_limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+// Decision is the outcome of Check
+type Decision struct {
+	// Allowed reports whether the token was taken
+	Allowed bool
+
+	// Limit is the rate of the rule
+	Limit int64
+
+	// Remaining is the number of tokens left after the check
+	Remaining int64
+
+	// RetryAfter is the wait until a denied key is allowed again, -1 when
+	// allowed
+	RetryAfter time.Duration
+
+	// ResetAfter is the wait until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Limiter manages the buckets of a middleware returned by Build, bound to
+// its store, prefix and limits. A key is the one of Config.Key, preceded
+// by "<route path>:" with IncludePath
+type Limiter struct {
+	store   Store
+	timeout time.Duration
+	warmUp  time.Duration
+	closer  *closer
+	esc     escalator
+
+	// buckets are the ones of Limits or of the base rule
+	buckets func(key string) []bucket
+
+	// methodBuckets are the ones of MethodLimits
+	methodBuckets func(key string) []bucket
+
+	// bucket is the one of a rule given at runtime, like the ones of
+	// LimitProvider
+	bucket func(key string, rule LimitRule) (bucket, error)
+
+	// take takes n tokens from buckets like the middleware, with the
+	// global bucket, Adaptive, Batch and FallbackToMemory
+	take func(ctx context.Context, buckets []bucket, n int) (*go_limiter.Result, error)
+}
+
+// Check takes a token of rule from key outside of a request, e.g. in a
+// gRPC interceptor or to gate a background job. Zero fields of rule are
+// filled like for Config.Limits and the bucket is the one a LimitProvider
+// returning rule would use, so the middleware and Check share the quota
+// of a key. The tokens are taken like by the middleware, GlobalMax,
+// Adaptive, Batch, FallbackToMemory and WarmUp included. Limits,
+// MethodLimits and MaxFunc are replaced by rule, Escalation, the callbacks
+// and the metrics need a request and aren't applied
+func (l *Limiter) Check(ctx context.Context, key string, rule LimitRule) (Decision, error) {
+	b, err := l.bucket(key, rule)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	ctx, cancel := l.context(ctx)
+	defer cancel()
+
+	r, err := l.take(ctx, []bucket{b}, 1)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return Decision{
+		Allowed:    r.Allowed,
+		Limit:      r.Limit.Rate,
+		Remaining:  r.Remaining,
+		RetryAfter: r.RetryAfter,
+		ResetAfter: r.ResetAfter,
+	}, nil
+}
+
+// Peek returns the current state of key without consuming a token, the
+// most restrictive one with more than one of Config.Limits. MaxFunc,
+// MethodLimits and LimitProvider need a request and aren't covered
+func (l *Limiter) Peek(key string) (*go_limiter.Result, error) {
+	ctx, cancel := l.context(context.Background())
+	defer cancel()
+
+	var result *go_limiter.Result
+	for _, b := range l.buckets(key) {
+		r, err := l.store.Peek(ctx, b.key, b.limit)
+		if err != nil {
+			return nil, err
+		}
+
+		result = mostRestrictive(result, r)
+	}
+
+	return result, nil
+}
+
+// Reset clears every bucket of key, including the ones of MethodLimits,
+// and lifts its Escalation ban and violations, so the next request starts
+// fresh
+func (l *Limiter) Reset(key string) error {
+	ctx, cancel := l.context(context.Background())
+	defer cancel()
+
+	for _, b := range append(l.buckets(key), l.methodBuckets(key)...) {
+		if err := l.store.Reset(ctx, b.key); err != nil {
+			return err
+		}
+	}
+
+	if l.esc != nil {
+		return l.esc.unban(ctx, key)
+	}
+
+	return nil
+}
+
+// Close releases what the middleware created itself, see NewWithCloser
+func (l *Limiter) Close() error {
+	return l.closer.Close()
+}
+
+func (l *Limiter) context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := withWarmUp(parent, l.warmUp)
+	if l.timeout > 0 {
+		return context.WithTimeout(ctx, l.timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,188 @@
+package echo_limiter
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/go_limiter"
+)
+
+// newBuilt returns an echo server behind the middleware built from config
+// and its Limiter
+func newBuilt(t testing.TB, config Config) (*echo.Echo, *Limiter) {
+	t.Helper()
+
+	m, l, err := Build(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	e := echo.New()
+	e.Use(m)
+	e.Any("/*", func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+
+	return e, l
+}
+
+func TestLimiter(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"redis", Config{Rediser: client}},
+		{"memory", Config{Store: NewMemoryStore()}},
+		{"limits", Config{Store: NewMemoryStore(), Limits: []LimitRule{{Max: 5, Period: time.Hour}, {Max: 3}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Max = 3
+			tt.config.Period = time.Minute
+			e, l := newBuilt(t, tt.config)
+			codes(e, 3)
+
+			r, err := l.Peek("192.0.2.1")
+			if err != nil || r.Allowed || r.Remaining != 0 {
+				t.Fatalf("peek = %+v (%v), want denied with 0 remaining", r, err)
+			}
+
+			if err := l.Reset("192.0.2.1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if r, err := l.Peek("192.0.2.1"); err != nil || !r.Allowed || r.Remaining != 3 {
+				t.Errorf("peek after reset = %+v (%v), want 3 remaining", r, err)
+			}
+
+			want := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+			if got := codes(e, 4); !reflect.DeepEqual(got, want) {
+				t.Errorf("codes after reset = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"redis", Config{Rediser: client}},
+		{"memory", Config{Store: NewMemoryStore()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, l := newBuilt(t, tt.config)
+			rule := LimitRule{Max: 2, Period: time.Minute}
+
+			want := []Decision{
+				{Allowed: true, Limit: 2, Remaining: 1, RetryAfter: -1},
+				{Allowed: true, Limit: 2, Remaining: 0, RetryAfter: -1},
+				{Allowed: false, Limit: 2, Remaining: 0},
+			}
+
+			for i, w := range want {
+				d, err := l.Check(context.Background(), "job", rule)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if d.Allowed != w.Allowed || d.Limit != w.Limit || d.Remaining != w.Remaining {
+					t.Errorf("check %d = %+v, want %+v", i, d, w)
+				}
+
+				if (w.Allowed && d.RetryAfter != -1) || (!w.Allowed && d.RetryAfter <= 0) {
+					t.Errorf("check %d: RetryAfter = %v", i, d.RetryAfter)
+				}
+			}
+
+			if _, err := l.Check(context.Background(), "job", LimitRule{Max: -1}); err == nil {
+				t.Error("no error for an invalid rule")
+			}
+		})
+	}
+}
+
+func TestCheckSharesTheMiddlewareQuota(t *testing.T) {
+	rule := LimitRule{Max: 2, Period: time.Minute}
+	e, l := newBuilt(t, Config{
+		Store: NewMemoryStore(),
+		LimitProvider: providerFunc(func(echo.Context) (LimitRule, error) {
+			return rule, nil
+		}),
+	})
+
+	if d, err := l.Check(context.Background(), "192.0.2.1", rule); err != nil || !d.Allowed {
+		t.Fatalf("check = %+v (%v), want allowed", d, err)
+	}
+
+	want := []int{http.StatusOK, http.StatusTooManyRequests}
+	if got := codes(e, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
+
+func TestCheckGlobalMax(t *testing.T) {
+	_, l := newBuilt(t, Config{Store: NewMemoryStore(), GlobalMax: 1})
+
+	want := []bool{true, false}
+	for i, key := range []string{"a", "b"} {
+		if d, err := l.Check(context.Background(), key, LimitRule{Max: 5}); err != nil || d.Allowed != want[i] {
+			t.Errorf("check %s = %+v (%v), want allowed %v", key, d, err, want[i])
+		}
+	}
+}
+
+func TestAllowedRetryAfter(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"sliding window", Config{Rediser: client, Prefix: "sliding"}},
+		{"gcra", Config{Rediser: client, Prefix: "gcra", Algorithm: GCRAAlgorithm}},
+		{"memory", Config{Store: NewMemoryStore()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, l, err := Build(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var retryAfter time.Duration
+			e := echo.New()
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error {
+				retryAfter = ctx.Get(DefaultContextKey).(*go_limiter.Result).RetryAfter
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			serve(e, http.MethodGet, "/")
+
+			d, err := l.Check(context.Background(), "192.0.2.1", LimitRule{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The middleware and Check share the decision of an allowance
+			if retryAfter != -1 || !d.Allowed || d.RetryAfter != -1 {
+				t.Errorf("middleware RetryAfter %v, Check %+v, want -1 for both", retryAfter, d)
+			}
+		})
+	}
+}
@@ -1,14 +1,23 @@
 package echo_limiter
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
 	"github.com/shareed2k/go_limiter"
 )
 
@@ -16,92 +25,933 @@ const (
 	SlidingWindowAlgorithm = go_limiter.SlidingWindowAlgorithm
 	GCRAAlgorithm          = go_limiter.GCRAAlgorithm
 	DefaultKeyPrefix       = "echo_limiter"
+	DefaultContextKey      = "ratelimit"
 	defaultMessage         = "Too many requests, please try again later."
 	defaultStatusCode      = http.StatusTooManyRequests
+	defaultLimitHeader     = "X-RateLimit-Limit"
+	defaultRemainingHeader = "X-RateLimit-Remaining"
+	defaultResetHeader     = "X-RateLimit-Reset"
+	defaultKeySeparator    = ":"
+	defaultErrorRetryAfter = 1
+	defaultErrorMessage    = "Service temporarily unavailable, please try again later."
+	defaultErrorStatusCode = http.StatusServiceUnavailable
+	defaultWarnHeader      = "X-RateLimit-Warning"
+	defaultScopeHeader     = "X-RateLimit-Scope"
+	defaultRuleHeader      = "X-RateLimit-Rule"
+
+	defaultMaxDelay = time.Second
+
+	// defaultContentLengthUnit is one token per KiB
+	defaultContentLengthUnit = 1024
+)
+
+const (
+	// RejectMode denies requests over the limit, DelayMode holds them back
+	// up to MaxDelay and serves them, see Config.Mode
+	RejectMode uint = iota
+	DelayMode
+)
+
+const (
+	// MissingKeyIP, MissingKeyDeny and MissingKeySkip are what happens to
+	// requests the Key func returned "" for, see Config.OnMissingKey
+	MissingKeyIP uint = iota
+	MissingKeyDeny
+	MissingKeySkip
 )
 
 var (
+	// DefaultConfig holds the values zero fields of a Config get. A copy
+	// of it shares its slices, so build configs on NewDefaultConfig
 	DefaultConfig = Config{
 		Skipper:    middleware.DefaultSkipper,
 		Max:        10,
 		Burst:      10,
 		StatusCode: defaultStatusCode,
 		Message:    defaultMessage,
-		Prefix:     DefaultKeyPrefix,
-		Algorithm:  SlidingWindowAlgorithm,
-		Period:     time.Minute,
+
+		ContentType: echo.MIMETextPlainCharsetUTF8,
+
+		ErrorStatusCode: defaultErrorStatusCode,
+		ErrorMessage:    defaultErrorMessage,
+
+		Prefix:    DefaultKeyPrefix,
+		Algorithm: SlidingWindowAlgorithm,
+		Period:    time.Minute,
+
+		KeySeparator: defaultKeySeparator,
+		ContextKey:   DefaultContextKey,
+		SkipMethods:  []string{http.MethodOptions},
+
+		LimitHeader:     defaultLimitHeader,
+		RemainingHeader: defaultRemainingHeader,
+		ResetHeader:     defaultResetHeader,
+
+		ContentLengthUnit: defaultContentLengthUnit,
+		MaxDelay:          defaultMaxDelay,
+		WarnHeader:        defaultWarnHeader,
+		ScopeHeader:       defaultScopeHeader,
+		RuleHeader:        defaultRuleHeader,
+
+		ConcurrencyTimeout: time.Minute,
+		QuietPeriod:        time.Minute,
+
+		IdempotencyMaxReplays: 10,
+
 		Key: func(ctx echo.Context) string {
 			return ctx.RealIP()
 		},
+		Cost: func(echo.Context) int {
+			return 1
+		},
+		Now: time.Now,
 	}
 )
 
 type (
+	// LimitRule is a single rate limit
+	LimitRule struct {
+		// Max number of requests per Period
+		Max int
+
+		// Burst is the gcra bucket size
+		// Default: Max
+		Burst int
+
+		// Period of the limit, sub-second periods like 500ms are supported
+		// down to the 1µs resolution of redis TIME. The headers are rounded
+		// up to whole seconds
+		// Default: 1 minute
+		Period time.Duration
+
+		// Algorithm
+		Algorithm uint
+	}
+
+	// LimitProvider resolves the rule of a request at runtime, e.g. the
+	// quota of an API key stored in a database
+	LimitProvider interface {
+		// Limit returns the rule for the caller of ctx, zero fields are
+		// filled like for Config.Limits. Caching is up to the provider
+		Limit(ctx echo.Context) (LimitRule, error)
+	}
+
+	// Tracer traces the store calls of every limited request, see the otel
+	// subpackage
+	Tracer interface {
+		// Start is called before the store calls for key, the store gets the
+		// returned context and end is called with their outcome
+		Start(ctx context.Context, key string, algorithm uint) (c context.Context, end func(*go_limiter.Result, error))
+	}
+
+	// Logger receives the errors and warnings of the limiter, echo.Logger
+	// satisfies it
+	Logger interface {
+		Error(i ...interface{})
+		Warn(i ...interface{})
+	}
+
+	// MessageData is what a MessageTemplate is rendered with, the durations
+	// are in seconds and all zero for Blacklist and ConcurrencyMax denials
+	MessageData struct {
+		Limit      int64
+		Remaining  int64
+		RetryAfter int64
+		ResetAfter int64
+	}
+
+	// MetricsCollector observes the outcome of every limited request
+	MetricsCollector interface {
+		// OnAllowed is called when a request passed the limiter
+		OnAllowed(echo.Context, *go_limiter.Result)
+
+		// OnDenied is called when a request hit the limit, the result is
+		// nil for blacklisted keys
+		OnDenied(echo.Context, *go_limiter.Result)
+
+		// OnError is called when the limiter failed
+		OnError(echo.Context, error)
+
+		// ObserveLatency is called with the duration of every limiter call
+		ObserveLatency(time.Duration)
+	}
+
+	// UtilizationCollector is a MetricsCollector also observing how much
+	// of its limit a key used, e.g. for a histogram of quota utilization.
+	// It's picked up from Config.MetricsCollector
+	UtilizationCollector interface {
+		MetricsCollector
+
+		// ObserveUtilization is called for every allowed or denied request
+		// with a result, with the share of its bucket used, from 0 for a
+		// full bucket to 1 for an empty or denied one
+		ObserveUtilization(echo.Context, float64)
+	}
+
+	// DelayCollector is a MetricsCollector also observing the requests
+	// DelayMode held back, e.g. for a histogram of the added latency. It's
+	// picked up from Config.MetricsCollector
+	DelayCollector interface {
+		MetricsCollector
+
+		// ObserveDelay is called for every request served after a wait,
+		// with the wait, before the OnAllowed of the request
+		ObserveDelay(echo.Context, time.Duration)
+	}
+
+	// bucket is where tokens of a request were taken from
+	bucket struct {
+		store Store
+		key   string
+		limit *go_limiter.Limit
+	}
+
 	Config struct {
 		Skipper middleware.Skipper
 
-		// Rediser
-		Rediser *redis.Client
+		// SkipMethods bypass the limiter altogether, no redis call and no
+		// headers, e.g. CORS preflights. An empty non-nil slice limits all
+		// methods
+		// Default: []string{"OPTIONS"}
+		SkipMethods []string
+
+		// WebSocketOnly limits WebSocket handshakes alone, see
+		// IsWebSocketUpgrade, other requests pass untouched. An upgraded
+		// connection lives on outside of the limiter, so this counts the
+		// connection attempts, e.g. to absorb reconnect storms
+		// Default: false
+		WebSocketOnly bool
+
+		// Include limits only the request paths matching one of its patterns
+		// when not empty. "/api" and "/api/*" match /api and the paths under
+		// it on segment boundaries, not /apiary, "/api*" is a plain prefix
+		// and any other pattern a path.Match glob, e.g. "/users/*/avatar"
+		// Default: nil (all paths)
+		Include []string
+
+		// Exclude skips the request paths matching one of its patterns, like
+		// for Include. It takes precedence over Include
+		// Default: nil
+		Exclude []string
+
+		// SkipperE is a Skipper that can fail, e.g. when the skip depends on
+		// a cache lookup. It takes precedence over Skipper when set and its
+		// error goes through ErrHandler
+		SkipperE func(echo.Context) (bool, error)
+
+		// Rediser is any go-redis client, a plain *redis.Client as well as
+		// cluster, sentinel (failover) or ring clients
+		Rediser redis.UniversalClient
+
+		// Scripter runs the limiter scripts instead of Rediser, e.g. to use
+		// a go-redis v8 client
+		Scripter Scripter
+
+		// Shards spreads the keys over several redis clients by their hash,
+		// see NewShardedStore, instead of Rediser. The state of
+		// ConcurrencyMax, Escalation, OnFirstDenied, Cardinality and
+		// IdempotencyHeader goes to the shard of its key too
+		Shards []redis.UniversalClient
+
+		// Store keeps the limits instead of redis, e.g. NewMemoryStore() for
+		// a single instance. One of Rediser, Scripter, Shards or Store is
+		// required
+		Store Store
+
+		// Batch checks the limits of a request, Limits, MethodLimits and
+		// GlobalMax, in a single round-trip when there is more than one and
+		// the Store is a BatchStore, like the redis one. The keys of a
+		// script have to live on the same node, so it's not for redis
+		// cluster or ring clients
+		// Default: false
+		Batch bool
 
 		// Max number of recent connections
 		// Default: 10
 		Max int
 
-		// Burst
+		// Limits are rules that all have to allow a request, e.g. 10 per
+		// second and 100 per minute. Headers reflect the most restrictive
+		// rule. Zero fields of a rule are taken from Max, Period and
+		// Algorithm, Burst defaults to the rule's Max. When empty the single
+		// rule given by Max, Burst, Period and Algorithm is used. Rules are
+		// checked in order, a denied request still counts against the
+		// rules before the one that denied it
+		// Default: nil
+		Limits []LimitRule
+
+		// MethodLimits holds rules for single HTTP methods, e.g. a tight
+		// POST and a loose GET limit. A listed method is limited by its rule
+		// alone, in a bucket of its own, other methods by the base config.
+		// Zero fields are filled like for Limits
+		// Default: nil
+		MethodLimits map[string]LimitRule
+
+		// LimitProvider is asked for the rule of every request, taking
+		// precedence over Max, Limits and MethodLimits. Every field may
+		// differ per key, e.g. a longer Period for premium accounts, and the
+		// headers follow the rule of the key. A key keeps its bucket when
+		// its Period changes, so its window may restart or be cut short, a
+		// new Algorithm starts a fresh bucket. Its errors and invalid rules
+		// go through SkipOnError / ErrHandler
+		// Default: nil
+		LimitProvider LimitProvider
+
+		// MaxFunc returns the max for the current request, e.g. a higher
+		// quota for authenticated users. Non-positive values fall back to Max.
+		// Ignored when Limits is set
+		// Default: nil
+		MaxFunc func(echo.Context) int
+
+		// Adaptive scales Max and Burst down while the next handlers answer
+		// with many 5xx, see Adaptive
+		// Default: nil
+		Adaptive *Adaptive
+
+		// Cardinality falls back to a coarser key, or denies, for sources
+		// using too many distinct keys, see Cardinality
+		// Default: nil
+		Cardinality *Cardinality
+
+		// Burst is the number of requests a gcra bucket can take at once,
+		// it must not exceed Max. Unused by the sliding window
+		// Default: Max
 		Burst int
 
+		// BurstPeriod sets the gcra burst as a window instead of a count, the
+		// time a full burst takes to refill at the sustained rate of Max per
+		// Period, e.g. Max 600 per hour with a BurstPeriod of 1 minute lets
+		// 10 requests through at once. Burst is derived from it once, so it
+		// doesn't follow MaxFunc. It must not exceed Period and can't be
+		// combined with Burst, a window below one emission interval is a
+		// burst of 1
+		// Default: 0 (use Burst)
+		BurstPeriod time.Duration
+
+		// BurstFunc returns the burst for the current request.
+		// Non-positive values fall back to Burst. Ignored when Limits is set
+		// Default: nil
+		BurstFunc func(echo.Context) int
+
+		// ConcurrencyMax caps the requests of a key in flight at the same
+		// time, e.g. 3 long-polls, in addition to the rate limits. Requests
+		// over it are denied like for Blacklist, with a nil result, without
+		// taking tokens. The slot is given back when the request ends, on
+		// panics and disconnects too. 0 disables it
+		// Default: 0
+		ConcurrencyMax int
+
+		// ConcurrencyTimeout is how long a slot counts at most, so requests
+		// of a crashed instance don't hold slots forever. Keep it above the
+		// longest request
+		// Default: 1 minute
+		ConcurrencyTimeout time.Duration
+
+		// WarmUp makes fresh gcra keys start with WarmUp worth of their
+		// burst spent, e.g. so clients can't spend a full burst at once
+		// after redis was flushed. It refills at the normal rate, so a key
+		// has its full burst after WarmUp. A key that returned to its
+		// initial state and expired counts as fresh again, at least one
+		// token is always left. Sliding window keys and custom stores are
+		// unaffected
+		// Default: 0
+		WarmUp time.Duration
+
+		// GlobalMax is a cap for all keys together, e.g. 10000 requests per
+		// minute protecting the whole service, checked in addition to the
+		// per key limits with Algorithm. 0 disables it
+		// Default: 0
+		GlobalMax int
+
+		// GlobalPeriod is the period of GlobalMax
+		// Default: Period
+		GlobalPeriod time.Duration
+
 		// StatusCode
 		// Default: 429 Too Many Requests
 		StatusCode int
 
+		// StatusCodeFunc returns the status of a denied request for the
+		// default Handler, e.g. 403 for bots and 429 for everyone else.
+		// Values that aren't 4xx or 5xx fall back to StatusCode
+		// Default: nil
+		StatusCodeFunc func(echo.Context) int
+
 		// Message
 		// default: "Too many requests, please try again later."
 		Message string
 
+		// ContentType is the Content-Type of the default Handler's plain
+		// text body, e.g. "text/plain; charset=us-ascii"
+		// Default: "text/plain; charset=UTF-8"
+		ContentType string
+
+		// MessageJSON makes the default Handler respond with a JSON body,
+		// {"message": Message, "retry_after": <delta-seconds>}
+		// Default: false (plain text)
+		MessageJSON bool
+
+		// MessageTemplate renders Message of the default Handler as a
+		// text/template with the denial in seconds, e.g. "Rate limit
+		// exceeded, retry in {{.RetryAfter}}s", see MessageData. An invalid
+		// template is an error of NewWithConfigE
+		// Default: false (literal Message)
+		MessageTemplate bool
+
 		// Algorithm
 		// Default: sliding window
 		Algorithm uint
 
+		// AlgorithmName sets Algorithm by name, see ParseAlgorithm, e.g.
+		// from a YAML config. Setting both to different algorithms is an
+		// error
+		// Default: ""
+		AlgorithmName string
+
 		// Prefix
-		// Default:
+		// Default: echo_limiter
 		Prefix string
 
+		// Namespace is prepended to Prefix, e.g. the service name when
+		// several services share a redis. The redis keys are
+		// "[<Namespace><KeySeparator>]<Prefix><KeySeparator><algorithm><KeySeparator><key>",
+		// e.g. "svcA:echo_limiter:sliding_window:1.2.3.4"
+		// Default: ""
+		Namespace string
+
+		// KeySeparator joins the parts of the redis keys, the route path
+		// with IncludePath and KeyParts
+		// Default: ":"
+		KeySeparator string
+
+		// DryRun runs the limiter, headers and callbacks included, but lets
+		// denied requests through to the next handler with a logged warning,
+		// e.g. to measure the impact of new limits before enforcing them
+		// Default: false
+		DryRun bool
+
+		// Mode is what happens to requests over the limit, RejectMode denies
+		// them and DelayMode sleeps for min(Retry-After, MaxDelay) before
+		// serving them anyway, e.g. to smooth background traffic. Served
+		// ones get the headers and callbacks of an allowed request with
+		// none remaining. Delayed requests hold their goroutine and
+		// connection while sleeping, so keep MaxDelay short, and get no
+		// response when the client leaves. Keys banned by Escalation are
+		// denied in either mode
+		// Default: RejectMode
+		Mode uint
+
+		// MaxDelay caps the sleep of DelayMode
+		// Default: 1 second
+		MaxDelay time.Duration
+
 		// SkipOnError
 		// Default: false
 		SkipOnError bool
 
-		// Period
+		// BypassHeader is set to "error" on requests SkipOnError let through
+		// unlimited, e.g. X-RateLimit-Bypassed, so monitoring can tell
+		// protection is degraded. An empty name skips it
+		// Default: ""
+		BypassHeader string
+
+		// OnBypass is called with the error when SkipOnError lets a request
+		// through unlimited, before the next handler
+		// Default: nil
+		OnBypass func(echo.Context, error)
+
+		// FailClosed denies requests when the store fails, writing
+		// ErrorStatusCode and ErrorMessage right away instead of calling
+		// ErrHandler, so an outage can't turn into a generic 500 of the echo
+		// error handler, e.g. for login endpoints. SkipOnError takes
+		// precedence
+		// Default: false
+		FailClosed bool
+
+		// QuietErrors logs an identical store error at most once per
+		// QuietPeriod, so an outage doesn't log on every request
+		// Default: false
+		QuietErrors bool
+
+		// QuietPeriod is the interval of QuietErrors
+		// Default: 1 minute
+		QuietPeriod time.Duration
+
+		// FallbackToMemory limits with an in-process limiter, keyed the same
+		// way and with the same limit, while redis returns errors. The
+		// fallback is per instance and not distributed. ConcurrencyMax and
+		// Cardinality fall back to in-process counts the same way. Takes
+		// precedence over SkipOnError
+		// Default: false
+		FallbackToMemory bool
+
+		// Period of the limit, sub-second periods like 500ms are supported
+		// down to the 1µs resolution of redis TIME. The headers are rounded
+		// up to whole seconds
+		// Default: 1 minute
 		Period time.Duration
 
+		// LimitHeader, RemainingHeader and ResetHeader are the names of the
+		// rate limit headers, e.g. RateLimit-Limit for the IETF draft names.
+		// When all three are empty the defaults are used, otherwise an
+		// empty name skips that header
+		// Default: X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset
+		LimitHeader     string
+		RemainingHeader string
+		ResetHeader     string
+
+		// DisableHeaders omits all rate limit headers, including Retry-After,
+		// limiting itself is unchanged
+		// Default: false
+		DisableHeaders bool
+
+		// DisableRetryAfter omits Retry-After, for clients misbehaving on
+		// it, keeping the other headers. Retry-After is only ever set on
+		// denied requests and limiter errors, never on allowed ones
+		// Default: false
+		DisableRetryAfter bool
+
+		// ResetAsDelta emits X-RateLimit-Reset as seconds until the reset
+		// instead of the Unix timestamp of the reset
+		// Default: false (Unix timestamp)
+		ResetAsDelta bool
+
+		// ResetAltHeader is the name of a header carrying the reset in the
+		// other form, the delta when ResetHeader is a Unix timestamp and the
+		// timestamp with ResetAsDelta, e.g. X-RateLimit-Reset-After. Both
+		// are computed from the same result. An empty name skips it
+		// Default: ""
+		ResetAltHeader string
+
+		// WarnThreshold is the share of the limit left, e.g. 0.1, below which
+		// allowed requests get WarnHeader so clients can slow down before
+		// they are denied. 0 disables the warning
+		// Default: 0
+		WarnThreshold float64
+
+		// WarnHeader is the name of the header set below WarnThreshold, its
+		// value is like "2 of 10 requests remaining"
+		// Default: X-RateLimit-Warning
+		WarnHeader string
+
+		// ScopeHeader is the name of the header telling denied requests
+		// which limit they hit, "global" for GlobalMax and "key" otherwise.
+		// Only set with a GlobalMax
+		// Default: X-RateLimit-Scope
+		ScopeHeader string
+
+		// RuleHeader is the name of the header telling denied requests the
+		// rule that denied them as "<max>/<period>", e.g. "100/1m". With
+		// more than one exceeded it's the most restrictive, the one with
+		// the longest Retry-After
+		// Default: X-RateLimit-Rule
+		RuleHeader string
+
+		// EmitPolicyHeader sets RateLimit-Policy of the IETF ratelimit
+		// headers draft, the quota and window of every limit of the request,
+		// e.g. "100;w=60" or "10;w=1, 100;w=60" with more than one
+		// Default: false
+		EmitPolicyHeader bool
+
+		// HeaderWriter sets the headers of a limited request from its
+		// result and rule, replacing the X-RateLimit-*, Retry-After, warn,
+		// scope and rule headers altogether, e.g. to follow other
+		// conventions. RateLimit-Policy of EmitPolicyHeader is set before
+		// it is called and DisableHeaders still skips it
+		// Default: the headers described above
+		HeaderWriter func(echo.Context, *go_limiter.Result, LimitRule)
+
+		// IncludePath scopes the key to the registered route path
+		// (ctx.Path()), so /login and /api get their own counters. It's the
+		// route template, /users/1 and /users/2 share the bucket of
+		// /users/:id, and requests no route matched share "unmatched"
+		// Default: false
+		IncludePath bool
+
+		// DebugKeyHeader is the name of a response header carrying the key
+		// returned by Key, e.g. X-RateLimit-Key. Meant for debugging, don't
+		// enable it in production
+		// Default: "" (disabled)
+		DebugKeyHeader string
+
+		// RetryAfterJitter adds a random 0..RetryAfterJitter to the advertised
+		// Retry-After of every denial, so clients don't retry all at once.
+		// Limiting itself is unchanged
+		// Default: 0
+		RetryAfterJitter time.Duration
+
+		// ContextKey is the echo.Context key the *go_limiter.Result of an
+		// allowed request is stored under, for the handlers behind. It's
+		// unset for requests served without a check, e.g. Whitelist,
+		// SkipMethods, SkipOnError and MissingKeySkip:
+		//   result, ok := ctx.Get(limiter.DefaultContextKey).(*go_limiter.Result)
+		//   if ok { ... }
+		// Default: "ratelimit"
+		ContextKey string
+
+		// Now is the clock for the timestamps in the headers, e.g. a fixed
+		// time in tests
+		// Default: time.Now
+		Now func() time.Time
+
+		// Timeout bounds a single call to redis, the request context is
+		// always propagated so a disconnected client cancels the call too.
+		// When exceeded the error goes through SkipOnError / ErrHandler
+		// Default: 0 (no timeout)
+		Timeout time.Duration
+
 		// Key allows to use a custom handler to create custom keys
 		// Default: func(echo.Context) string {
 		//   return ctx.RealIP()
 		// }
 		Key func(echo.Context) string
 
-		// Handler is called when a request hits the limit
+		// OnMissingKey is what happens when Key returns "", e.g. for a
+		// missing header, instead of limiting all of them in one bucket:
+		// MissingKeyIP limits by ctx.RealIP(), MissingKeyDeny denies like
+		// for Blacklist and MissingKeySkip skips the limiter
+		// Default: MissingKeyIP
+		OnMissingKey uint
+
+		// KeyParts build the key from several parts, e.g. user and route,
+		// joined with KeySeparator and skipping empty parts. Takes precedence
+		// over Key
+		// Default: nil
+		KeyParts []func(echo.Context) string
+
+		// Cost is the number of tokens the request consumes, e.g. 10 for a
+		// bulk export and 1 for a health check. Values below 1 count as 1
+		// Default: func(echo.Context) int {
+		//   return 1
+		// }
+		Cost func(echo.Context) int
+
+		// WeightByContentLength charges ceil(Content-Length / ContentLengthUnit)
+		// tokens, e.g. for upload routes, instead of Cost. A request without
+		// a known Content-Length is charged Cost. Costs over the largest
+		// limit are capped to it plus 1, they are denied all the same
+		// Default: false
+		WeightByContentLength bool
+
+		// ContentLengthUnit is the number of bytes one token stands for
+		// with WeightByContentLength
+		// Default: 1024
+		ContentLengthUnit int64
+
+		// Whitelist holds keys, as returned by Key, that are never limited.
+		// Whitelisted requests don't touch redis and get no rate limit headers
+		// Default: nil
+		Whitelist []string
+
+		// Blacklist holds keys, as returned by Key, that are always rejected
+		// through Handler without touching redis. Takes precedence over
+		// Whitelist
+		// Default: nil
+		Blacklist []string
+
+		// MetricsCollector receives allowed, denied and error outcomes and
+		// the latency of the limiter calls, see the prometheus subpackage.
+		// An UtilizationCollector also gets the quota utilization, a
+		// DelayCollector the waits of DelayMode
+		// Default: nil
+		MetricsCollector MetricsCollector
+
+		// Tracer wraps the store calls of every request in a span, nested in
+		// the span of ctx.Request().Context(), see the otel subpackage
+		// Default: nil
+		Tracer Tracer
+
+		// Logger receives store errors and dry run warnings instead of
+		// ctx.Logger(), e.g. to route them to a structured logger
+		// Default: nil
+		Logger Logger
+
+		// OnAllowed is called when a request passed the limiter, after the
+		// headers are set and before the next handler, e.g. for auditing.
+		// It can't change the response flow
+		// Default: nil
+		OnAllowed func(echo.Context, *go_limiter.Result)
+
+		// OnDenied is called when a request hit the limit, after the headers
+		// are set and before Handler. It can't change the response flow
+		// Default: nil
+		OnDenied func(echo.Context, *go_limiter.Result)
+
+		// Escalation bans keys exceeding the limit again and again, every
+		// request of a banned key is denied right away without taking
+		// tokens until the ban expires. Tracked in redis, in memory with a
+		// custom Store, e.g. []EscalationRule{{Violations: 10, Window:
+		// time.Minute, Ban: time.Hour}}
+		// Default: nil
+		Escalation []EscalationRule
+
+		// OnFirstDenied is like OnDenied but only called when a key goes
+		// from allowed to denied, not for the denials that follow, e.g. for
+		// alerting. The state is a redis flag living for the Retry-After of
+		// the denial, without RetryAfterJitter, and refreshed by the denials
+		// that follow. It isn't cleared by an allowed request, so a key
+		// denied again before the flag expired doesn't call it. Kept in
+		// memory with a custom Store, so the de-dup is best-effort across
+		// instances
+		// Default: nil
+		OnFirstDenied func(echo.Context, *go_limiter.Result)
+
+		// IdempotencyHeader is the name of a header, e.g. Idempotency-Key,
+		// whose repeated values within IdempotencyWindow take tokens once,
+		// so retries of an idempotent request don't burn the quota. Values
+		// are scoped to the key and hashed and only count as seen once a
+		// request took its tokens, failed, denied and concurrent requests
+		// with the value take their own. Replays take no tokens but are
+		// still denied while the key is banned or any of its limits,
+		// GlobalMax included, has none left. Tracked in redis, in memory
+		// with a custom Store. An empty name disables it
+		// Default: ""
+		IdempotencyHeader string
+
+		// IdempotencyWindow is how long a value of IdempotencyHeader is
+		// remembered from the first request that took tokens
+		// Default: Period
+		IdempotencyWindow time.Duration
+
+		// IdempotencyMaxReplays caps the replays of a value within
+		// IdempotencyWindow, later ones take tokens like a new request, so
+		// a client can't resend one value for free forever
+		// Default: 10
+		IdempotencyMaxReplays int
+
+		// SkipFunc is called after the next handler returned, a true result
+		// refunds the request's tokens so it doesn't count, e.g. to count
+		// only failed logins:
+		//   func(ctx echo.Context, err error) bool {
+		//     return limiter.ResponseStatus(ctx, err) < 400
+		//   }
+		// Refunds are best-effort, concurrent requests may still see the
+		// tokens as taken
+		// Default: nil
+		SkipFunc func(echo.Context, error) bool
+
+		// RefundOn is called after the next handler returned, a true result
+		// gives the request's tokens back, e.g. when a proxied upstream
+		// responded with 502:
+		//   func(ctx echo.Context) bool {
+		//     return ctx.Response().Status == http.StatusBadGateway
+		//   }
+		// The status is only set once the response is written, use SkipFunc
+		// for handlers returning errors. Refunds are best-effort and may race
+		// with concurrent requests of the same key
+		// Default: nil
+		RefundOn func(echo.Context) bool
+
+		// RefundOnPanic gives the tokens of a request back when the next
+		// handler panics, the panic is then passed on. It only sees panics
+		// when the limiter runs inside middleware.Recover, e.Use(Recover())
+		// before the limiter, a Recover after it turns the panic into an
+		// error for SkipFunc. Refunds are best-effort like for SkipFunc
+		// Default: false
+		RefundOnPanic bool
+
+		// Handler is called when a request hits the limit and ends the
+		// chain: whatever it returns, nil included, the next handlers and
+		// the middleware after the limiter never run for that request. A
+		// nil return without a written response leaves an empty 200, so it
+		// should write one. Only DryRun and DelayMode serve limited requests
 		// Default: func(c echo.Context) {
 		//   return ctx.String(defaultStatusCode, defaultMessage)
 		// }
 		Handler func(echo.Context) error
 
-		// ErrHandler is called when a error happen inside go_limiiter lib
-		// Default: func(c echo.Context) {
-		//   return ctx.String(defaultStatusCode, defaultMessage)
+		// LimitReachedHandler is called instead of Handler when set, with
+		// the result that denied the request, e.g. to render RetryAfter.
+		// The result is nil for blacklisted keys. It ends the chain like
+		// Handler
+		// Default: nil
+		LimitReachedHandler func(echo.Context, *go_limiter.Result) error
+
+		// ErrorStatusCode is the status of the default ErrHandler
+		// Default: 503 Service Unavailable
+		ErrorStatusCode int
+
+		// ErrorMessage is the message of the default ErrHandler, the error
+		// itself is only logged
+		// Default: "Service temporarily unavailable, please try again later."
+		ErrorMessage string
+
+		// ErrHandler is called when a error happen inside the limiter, e.g.
+		// redis is unreachable
+		// Default: func(err error, ctx echo.Context) error {
+		//   ctx.Response().Header().Set("Retry-After", "1")
+		//   return echo.NewHTTPError(ErrorStatusCode, ErrorMessage)
 		// }
 		ErrHandler func(error, echo.Context) error
+
+		// owned is a redis client created by the limiter itself, released
+		// by the Closer of NewWithCloser
+		owned io.Closer
+
+		// shutdown is the echo server closing owned on shutdown
+		shutdown *echo.Echo
 	}
 )
 
-func New(rediser *redis.Client) echo.MiddlewareFunc {
-	config := DefaultConfig
+// limitRule is the LimitRule of l
+func limitRule(l *go_limiter.Limit) LimitRule {
+	return LimitRule{
+		Max:       int(l.Rate),
+		Burst:     int(l.Burst),
+		Period:    l.Period,
+		Algorithm: l.Algorithm,
+	}
+}
+
+func (r LimitRule) limit() *go_limiter.Limit {
+	return &go_limiter.Limit{
+		Period:    r.Period,
+		Algorithm: r.Algorithm,
+		Rate:      int64(r.Max),
+		Burst:     int64(r.Burst),
+	}
+}
+
+// New returns the middleware with the default config and opts applied in
+// order, e.g. New(client, WithMax(100), WithPeriod(time.Hour))
+func New(rediser redis.UniversalClient, opts ...Option) echo.MiddlewareFunc {
+	config := configWith(opts)
+	config.Rediser = rediser
+	return NewWithConfig(config)
+}
+
+// NewE is like New but returns an error instead of panicking
+func NewE(rediser redis.UniversalClient, opts ...Option) (echo.MiddlewareFunc, error) {
+	config := configWith(opts)
 	config.Rediser = rediser
+	return NewWithConfigE(config)
+}
+
+// NewDefaultConfig returns a copy of DefaultConfig sharing none of its
+// slices, maps and pointers, so changing it can't leak into other configs
+func NewDefaultConfig() Config {
+	return DefaultConfig.clone()
+}
+
+// clone returns config with its slices, maps and pointed structs copied,
+// nil and empty ones stay as they are
+func (config Config) clone() Config {
+	config.SkipMethods = cloneStrings(config.SkipMethods)
+	config.Include = cloneStrings(config.Include)
+	config.Exclude = cloneStrings(config.Exclude)
+	config.Whitelist = cloneStrings(config.Whitelist)
+	config.Blacklist = cloneStrings(config.Blacklist)
+
+	if config.Shards != nil {
+		config.Shards = append(make([]redis.UniversalClient, 0, len(config.Shards)), config.Shards...)
+	}
+
+	if config.Limits != nil {
+		config.Limits = append(make([]LimitRule, 0, len(config.Limits)), config.Limits...)
+	}
+
+	if config.MethodLimits != nil {
+		rules := make(map[string]LimitRule, len(config.MethodLimits))
+		for method, rule := range config.MethodLimits {
+			rules[method] = rule
+		}
+
+		config.MethodLimits = rules
+	}
+
+	if config.KeyParts != nil {
+		config.KeyParts = append(make([]func(echo.Context) string, 0, len(config.KeyParts)), config.KeyParts...)
+	}
+
+	if config.Escalation != nil {
+		config.Escalation = append(make([]EscalationRule, 0, len(config.Escalation)), config.Escalation...)
+	}
+
+	if config.Adaptive != nil {
+		adaptive := *config.Adaptive
+		config.Adaptive = &adaptive
+	}
+
+	if config.Cardinality != nil {
+		cardinality := *config.Cardinality
+		config.Cardinality = &cardinality
+	}
+
+	return config
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// configWith returns DefaultConfig with opts applied, the burst is unset
+// so it follows WithMax unless WithBurst is given
+func configWith(opts []Option) Config {
+	config := NewDefaultConfig()
+	config.Burst = 0
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return config
+}
+
+// NewWithStore returns the middleware with the default config, keeping
+// the limits in store, e.g. a testutil.Store in tests
+func NewWithStore(store Store) echo.MiddlewareFunc {
+	config := NewDefaultConfig()
+	config.Store = store
 	return NewWithConfig(config)
 }
 
+// NewWithConfig returns the middleware for config, zero fields are set to
+// their defaults. It panics when config is invalid, see NewWithConfigE
 func NewWithConfig(config Config) echo.MiddlewareFunc {
-	if config.Rediser == nil {
-		panic(errors.New("redis client is missing"))
+	m, err := NewWithConfigE(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// NewWithConfigE is like NewWithConfig but returns an error for a missing
+// Rediser or an invalid config, e.g. a negative Max or Period, instead of
+// panicking
+func NewWithConfigE(config Config) (echo.MiddlewareFunc, error) {
+	m, _, err := Build(config)
+	return m, err
+}
+
+// Build is like NewWithConfigE but also returns a Limiter to manage the
+// buckets of the middleware, e.g. from an admin endpoint
+func Build(config Config) (echo.MiddlewareFunc, *Limiter, error) {
+	if config.Rediser == nil && config.Scripter == nil && len(config.Shards) == 0 && config.Store == nil {
+		return nil, nil, errors.New("redis client is missing")
+	}
+
+	// The caller may still change its slices and maps
+	config = config.clone()
+
+	if config.AlgorithmName != "" {
+		algorithm, err := ParseAlgorithm(config.AlgorithmName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if config.Algorithm != 0 && config.Algorithm != algorithm {
+			return nil, nil, fmt.Errorf("algorithm %d conflicts with algorithm name %q", config.Algorithm, config.AlgorithmName)
+		}
+
+		config.Algorithm = algorithm
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, nil, err
 	}
 
 	if config.Skipper == nil {
@@ -112,8 +962,11 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 		config.Max = DefaultConfig.Max
 	}
 
-	if config.Burst == 0 {
-		config.Burst = DefaultConfig.Burst
+	// With gcra the burst is the bucket size, an unset one is the max so
+	// the bucket holds one period worth of requests
+	burstSet := config.Burst != 0
+	if !burstSet {
+		config.Burst = config.Max
 	}
 
 	if config.StatusCode == 0 {
@@ -124,6 +977,10 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 		config.Message = DefaultConfig.Message
 	}
 
+	if config.ContentType == "" {
+		config.ContentType = DefaultConfig.ContentType
+	}
+
 	if config.Algorithm == 0 {
 		config.Algorithm = DefaultConfig.Algorithm
 	}
@@ -136,67 +993,1255 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 		config.Period = DefaultConfig.Period
 	}
 
+	if config.BurstPeriod > 0 {
+		// One token is emitted every Period / Max
+		config.Burst = int(math.Max(1, math.Floor(float64(config.Max)*float64(config.BurstPeriod)/float64(config.Period))))
+		burstSet = true
+	}
+
+	if config.LimitHeader == "" && config.RemainingHeader == "" && config.ResetHeader == "" {
+		config.LimitHeader = DefaultConfig.LimitHeader
+		config.RemainingHeader = DefaultConfig.RemainingHeader
+		config.ResetHeader = DefaultConfig.ResetHeader
+	}
+
+	if config.KeySeparator == "" {
+		config.KeySeparator = DefaultConfig.KeySeparator
+	}
+
+	prefix := config.Prefix
+	if config.Namespace != "" {
+		prefix = config.Namespace + config.KeySeparator + prefix
+	}
+
+	if len(config.KeyParts) > 0 {
+		parts := append([]func(echo.Context) string(nil), config.KeyParts...)
+		config.Key = func(ctx echo.Context) string {
+			values := make([]string, 0, len(parts))
+			for _, part := range parts {
+				if v := part(ctx); v != "" {
+					values = append(values, v)
+				}
+			}
+
+			return strings.Join(values, config.KeySeparator)
+		}
+	}
+
 	if config.Key == nil {
 		config.Key = DefaultConfig.Key
 	}
 
-	if config.Handler == nil {
-		config.Handler = func(ctx echo.Context) error {
-			return ctx.String(config.StatusCode, config.Message)
-		}
+	if config.ContextKey == "" {
+		config.ContextKey = DefaultContextKey
 	}
 
-	if config.ErrHandler == nil {
-		config.ErrHandler = func(err error, ctx echo.Context) error {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+	if config.Now == nil {
+		config.Now = DefaultConfig.Now
+	}
+
+	if config.Cost == nil {
+		config.Cost = DefaultConfig.Cost
 	}
 
-	limiter := go_limiter.NewLimiter(config.Rediser)
-	limiter.Prefix = config.Prefix
+	if config.ContentLengthUnit == 0 {
+		config.ContentLengthUnit = DefaultConfig.ContentLengthUnit
+	}
 
-	limit := &go_limiter.Limit{
-		Period:    config.Period,
-		Algorithm: config.Algorithm,
-		Rate:      int64(config.Max),
-		Burst:     int64(config.Burst),
+	if config.ConcurrencyTimeout == 0 {
+		config.ConcurrencyTimeout = DefaultConfig.ConcurrencyTimeout
 	}
 
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(ctx echo.Context) error {
-			if config.Skipper(ctx) {
-				return next(ctx)
-			}
+	if config.QuietPeriod == 0 {
+		config.QuietPeriod = DefaultConfig.QuietPeriod
+	}
 
-			result, err := limiter.Allow(config.Key(ctx), limit)
-			if err != nil {
-				ctx.Logger().Error(err)
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultConfig.MaxDelay
+	}
 
-				if config.SkipOnError {
-					return next(ctx)
-				}
+	if config.WarnHeader == "" {
+		config.WarnHeader = DefaultConfig.WarnHeader
+	}
 
-				return config.ErrHandler(err, ctx)
-			}
+	if config.ScopeHeader == "" {
+		config.ScopeHeader = DefaultConfig.ScopeHeader
+	}
 
-			res := ctx.Response()
+	if config.RuleHeader == "" {
+		config.RuleHeader = DefaultConfig.RuleHeader
+	}
 
-			// Check if hits exceed the max
-			if !result.Allowed {
-				// Return response with Retry-After header
-				// https://tools.ietf.org/html/rfc6584
-				res.Header().Set("Retry-After", strconv.FormatInt(time.Now().Add(result.RetryAfter).Unix(), 10))
+	if config.GlobalPeriod == 0 {
+		config.GlobalPeriod = config.Period
+	}
+
+	if config.IdempotencyWindow == 0 {
+		config.IdempotencyWindow = config.Period
+	}
+
+	if config.IdempotencyMaxReplays == 0 {
+		config.IdempotencyMaxReplays = DefaultConfig.IdempotencyMaxReplays
+	}
+
+	// message renders Message for result, which is nil for Blacklist and
+	// ConcurrencyMax denials
+	message := func(*go_limiter.Result) string {
+		return config.Message
+	}
+
+	if config.MessageTemplate {
+		tmpl, err := template.New("message").Parse(config.Message)
+		if err != nil {
+			return nil, nil, fmt.Errorf("message template: %w", err)
+		}
 
-				// Call Handler func
-				return config.Handler(ctx)
+		message = func(result *go_limiter.Result) string {
+			var data MessageData
+			if result != nil {
+				data = MessageData{
+					Limit:      result.Limit.Rate,
+					Remaining:  result.Remaining,
+					RetryAfter: seconds(result.RetryAfter),
+					ResetAfter: seconds(result.ResetAfter),
+				}
 			}
 
-			// We can continue, update RateLimit headers
-			res.Header().Set("X-RateLimit-Limit", strconv.Itoa(config.Max))
-			res.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
-			res.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.ResetAfter).Unix(), 10))
+			var b strings.Builder
+			if err := tmpl.Execute(&b, data); err != nil {
+				return config.Message
+			}
 
-			return next(ctx)
+			return b.String()
 		}
 	}
+
+	status := func(ctx echo.Context) int {
+		if config.StatusCodeFunc != nil {
+			if code := config.StatusCodeFunc(ctx); code >= http.StatusBadRequest && code <= 599 {
+				return code
+			}
+		}
+
+		return config.StatusCode
+	}
+
+	// limitReached renders the denial, the default Handler can't see the
+	// result so MessageJSON and MessageTemplate are handled here
+	limitReached := config.LimitReachedHandler
+	if limitReached == nil {
+		limitReached = func(ctx echo.Context, result *go_limiter.Result) error {
+			return config.Handler(ctx)
+		}
+	}
+
+	if config.Handler == nil {
+		config.Handler = func(ctx echo.Context) error {
+			return ctx.Blob(status(ctx), config.ContentType, []byte(config.Message))
+		}
+
+		if config.LimitReachedHandler == nil {
+			limitReached = func(ctx echo.Context, result *go_limiter.Result) error {
+				return ctx.Blob(status(ctx), config.ContentType, []byte(message(result)))
+			}
+		}
+
+		if config.MessageJSON && config.LimitReachedHandler == nil {
+			limitReached = func(ctx echo.Context, result *go_limiter.Result) error {
+				body := map[string]interface{}{
+					"message": message(result),
+				}
+
+				if result != nil {
+					body["retry_after"] = seconds(result.RetryAfter)
+				}
+
+				return ctx.JSON(status(ctx), body)
+			}
+		}
+	}
+
+	if config.ErrorStatusCode == 0 {
+		config.ErrorStatusCode = DefaultConfig.ErrorStatusCode
+	}
+
+	if config.ErrorMessage == "" {
+		config.ErrorMessage = DefaultConfig.ErrorMessage
+	}
+
+	if config.ErrHandler == nil {
+		config.ErrHandler = func(err error, ctx echo.Context) error {
+			// The store is likely to be back soon, so it is temporary
+			if !config.DisableHeaders && !config.DisableRetryAfter {
+				ctx.Response().Header().Set("Retry-After", strconv.Itoa(defaultErrorRetryAfter))
+			}
+
+			return echo.NewHTTPError(config.ErrorStatusCode, config.ErrorMessage)
+		}
+	}
+
+	normalize := func(rule LimitRule) LimitRule {
+		if rule.Max == 0 {
+			rule.Max = config.Max
+		}
+
+		if rule.Burst == 0 {
+			rule.Burst = rule.Max
+		}
+
+		if rule.Period == 0 {
+			rule.Period = config.Period
+		}
+
+		if rule.Algorithm == 0 {
+			rule.Algorithm = config.Algorithm
+		}
+
+		return rule
+	}
+
+	rules := make([]LimitRule, len(config.Limits))
+	for i, rule := range config.Limits {
+		rules[i] = normalize(rule)
+	}
+
+	methodRules := make(map[string]LimitRule, len(config.MethodLimits))
+	for method, rule := range config.MethodLimits {
+		methodRules[strings.ToUpper(method)] = normalize(rule)
+	}
+
+	// global is the bucket shared by all keys, its key has "global" where
+	// the others have their algorithm so it can't clash with any of them
+	var global *bucket
+	if config.GlobalMax > 0 {
+		l := LimitRule{
+			Max:       config.GlobalMax,
+			Burst:     config.GlobalMax,
+			Period:    config.GlobalPeriod,
+			Algorithm: config.Algorithm,
+		}.limit()
+
+		global = &bucket{key: storeKey(prefix+config.KeySeparator+"global", config.KeySeparator, l.Algorithm, "all"), limit: l}
+	}
+
+	// ruleBuckets returns the buckets of Limits for key, or the one of the
+	// base rule with max and burst
+	ruleBuckets := func(key string, max, burst int) []bucket {
+		if len(rules) > 0 {
+			buckets := make([]bucket, len(rules))
+			for i, rule := range rules {
+				l, k := rule.limit(), key
+				if len(rules) > 1 {
+					// Every rule needs its own bucket
+					k += config.KeySeparator + l.Period.String()
+				}
+
+				buckets[i] = bucket{key: storeKey(prefix, config.KeySeparator, l.Algorithm, k), limit: l}
+			}
+
+			return buckets
+		}
+
+		rule := LimitRule{
+			Max:       max,
+			Burst:     burst,
+			Period:    config.Period,
+			Algorithm: config.Algorithm,
+		}
+
+		l := rule.limit()
+		return []bucket{{key: storeKey(prefix, config.KeySeparator, l.Algorithm, key), limit: l}}
+	}
+
+	// checkedBucket returns the bucket of a rule given at runtime for key,
+	// zero fields filled like for Limits
+	checkedBucket := func(key string, rule LimitRule) (bucket, error) {
+		if err := rule.validate(config.Max, config.Algorithm); err != nil {
+			return bucket{}, err
+		}
+
+		l := normalize(rule).limit()
+		return bucket{key: storeKey(prefix, config.KeySeparator, l.Algorithm, key), limit: l}, nil
+	}
+
+	// limits returns the buckets key is limited by
+	limits := func(ctx echo.Context, key string) ([]bucket, error) {
+		if config.LimitProvider != nil {
+			rule, err := config.LimitProvider.Limit(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := checkedBucket(key, rule)
+			if err != nil {
+				return nil, fmt.Errorf("limit provider: %w", err)
+			}
+
+			return []bucket{b}, nil
+		}
+
+		method := ctx.Request().Method
+		if rule, ok := methodRules[method]; ok {
+			l := rule.limit()
+			return []bucket{{key: storeKey(prefix, config.KeySeparator, l.Algorithm, method+config.KeySeparator+key), limit: l}}, nil
+		}
+
+		max, burst := config.Max, config.Burst
+		if len(rules) > 0 {
+			return ruleBuckets(key, max, burst), nil
+		}
+
+		if config.MaxFunc != nil {
+			if m := config.MaxFunc(ctx); m > 0 {
+				max = m
+				if !burstSet {
+					burst = m
+				}
+			}
+		}
+
+		if config.BurstFunc != nil {
+			if b := config.BurstFunc(ctx); b > 0 {
+				burst = b
+			}
+		}
+
+		return ruleBuckets(key, max, burst), nil
+	}
+
+	reset := func(d time.Duration) int64 {
+		if config.ResetAsDelta {
+			return seconds(d)
+		}
+
+		return config.Now().Add(d).Unix()
+	}
+
+	resetAlt := func(d time.Duration) int64 {
+		if config.ResetAsDelta {
+			return config.Now().Add(d).Unix()
+		}
+
+		return seconds(d)
+	}
+
+	// fallback is the logger outside of a request, like echo's default
+	fallback := log.New("echo")
+
+	// logger is Config.Logger, or the echo logger of the request
+	logger := func(ctx echo.Context) Logger {
+		if config.Logger != nil {
+			return config.Logger
+		}
+
+		if ctx == nil {
+			return fallback
+		}
+
+		return ctx.Logger()
+	}
+
+	var quiet *throttle
+	if config.QuietErrors {
+		quiet = newThrottle(config.QuietPeriod)
+	}
+
+	// logError logs err, unless QuietErrors logged the same one recently
+	logError := func(ctx echo.Context, err error) {
+		if quiet == nil || quiet.allow(err.Error(), config.Now()) {
+			logger(ctx).Error(err)
+		}
+	}
+
+	// headers sets the RateLimit headers of result, for allowed and
+	// denied requests alike
+	headers := func(res *echo.Response, result *go_limiter.Result) {
+		setHeader(res, config.LimitHeader, strconv.FormatInt(result.Limit.Rate, 10))
+		setHeader(res, config.RemainingHeader, strconv.FormatInt(result.Remaining, 10))
+		setHeader(res, config.ResetHeader, strconv.FormatInt(reset(result.ResetAfter), 10))
+		setHeader(res, config.ResetAltHeader, strconv.FormatInt(resetAlt(result.ResetAfter), 10))
+	}
+
+	if config.HeaderWriter == nil {
+		config.HeaderWriter = func(ctx echo.Context, result *go_limiter.Result, rule LimitRule) {
+			res := ctx.Response()
+
+			if result.Allowed {
+				headers(res, result)
+
+				if float64(result.Remaining) < config.WarnThreshold*float64(result.Limit.Rate) {
+					res.Header().Set(config.WarnHeader, fmt.Sprintf("%d of %d requests remaining", result.Remaining, result.Limit.Rate))
+				}
+
+				return
+			}
+
+			// Return response with Retry-After header in delta-seconds
+			// https://tools.ietf.org/html/rfc7231#section-7.1.3
+			if !config.DisableRetryAfter {
+				res.Header().Set("Retry-After", strconv.FormatInt(seconds(result.RetryAfter), 10))
+			}
+			headers(res, result)
+			res.Header().Set(config.RuleHeader, rule.String())
+
+			if global != nil {
+				hit := "key"
+				if result.Key == global.key {
+					hit = "global"
+				}
+
+				res.Header().Set(config.ScopeHeader, hit)
+			}
+		}
+	}
+
+	scope := func(ctx echo.Context, key string) string {
+		if config.IncludePath {
+			return routePath(ctx) + config.KeySeparator + key
+		}
+
+		return key
+	}
+
+	cost := func(ctx echo.Context) int {
+		if length := ctx.Request().ContentLength; config.WeightByContentLength && length > 0 {
+			// Divided first, a length near math.MaxInt64 must not overflow
+			n := length / config.ContentLengthUnit
+			if length%config.ContentLengthUnit != 0 {
+				n++
+			}
+
+			if n > math.MaxInt32 {
+				n = math.MaxInt32
+			}
+
+			return int(n)
+		}
+
+		if n := config.Cost(ctx); n > 1 {
+			return n
+		}
+
+		return 1
+	}
+
+	whitelist := make(map[string]struct{}, len(config.Whitelist))
+	for _, key := range config.Whitelist {
+		whitelist[key] = struct{}{}
+	}
+
+	blacklist := make(map[string]struct{}, len(config.Blacklist))
+	for _, key := range config.Blacklist {
+		blacklist[key] = struct{}{}
+	}
+
+	scripter := config.Scripter
+	if scripter == nil && config.Rediser != nil {
+		scripter = NewScripter(config.Rediser)
+	}
+
+	store := config.Store
+	if store == nil && len(config.Shards) > 0 {
+		shards := make([]Store, len(config.Shards))
+		scripters := make([]Scripter, len(config.Shards))
+		for i, client := range config.Shards {
+			scripters[i] = NewScripter(client)
+			shards[i] = NewRedisStore(scripters[i])
+		}
+
+		store = NewShardedStore(shards...)
+
+		if scripter == nil {
+			scripter = &shardedScripter{shards: scripters}
+		}
+	}
+
+	if store == nil {
+		store = NewRedisStore(scripter)
+	}
+
+	utilized, _ := config.MetricsCollector.(UtilizationCollector)
+	delays, _ := config.MetricsCollector.(DelayCollector)
+
+	var batch BatchStore
+	if config.Batch {
+		batch, _ = store.(BatchStore)
+	}
+
+	var adapt *adaptive
+	if config.Adaptive != nil {
+		adapt = newAdaptive(*config.Adaptive)
+	}
+
+	// countedMemory counts the keys of FallbackToMemory while redis fails
+	var guard *Cardinality
+	var counted, countedMemory cardinality
+	if config.Cardinality != nil {
+		c := config.Cardinality.withDefaults()
+		guard = &c
+
+		if scripter != nil {
+			counted = &redisCardinality{scripter: scripter, prefix: prefix + config.KeySeparator + "cardinality" + config.KeySeparator, max: c.Max, window: c.Window}
+			if config.FallbackToMemory {
+				countedMemory = newMemoryCardinality(c.Max, c.Window)
+			}
+		} else {
+			counted = newMemoryCardinality(c.Max, c.Window)
+		}
+	}
+
+	paths := matcher{include: config.Include, exclude: config.Exclude}
+
+	if config.SkipMethods == nil {
+		config.SkipMethods = cloneStrings(DefaultConfig.SkipMethods)
+	}
+
+	skipMethods := make(map[string]struct{}, len(config.SkipMethods))
+	for _, method := range config.SkipMethods {
+		skipMethods[strings.ToUpper(method)] = struct{}{}
+	}
+
+	// concMemory holds the slots of FallbackToMemory while redis fails
+	var conc, concMemory inflight
+	if config.ConcurrencyMax > 0 {
+		if scripter != nil {
+			conc = &redisInflight{scripter: scripter, max: config.ConcurrencyMax, timeout: config.ConcurrencyTimeout}
+			if config.FallbackToMemory {
+				concMemory = &memoryInflight{max: config.ConcurrencyMax, count: make(map[string]int)}
+			}
+		} else {
+			conc = &memoryInflight{max: config.ConcurrencyMax, count: make(map[string]int)}
+		}
+	}
+
+	var esc escalator
+	if len(config.Escalation) > 0 {
+		if scripter != nil {
+			esc = &redisEscalator{scripter: scripter, prefix: prefix, separator: config.KeySeparator, rules: config.Escalation}
+		} else {
+			esc = &memoryEscalator{rules: config.Escalation, violations: make(map[string]*violations), bans: make(map[string]time.Time)}
+		}
+	}
+
+	var denied deniedFlags
+	if config.OnFirstDenied != nil {
+		if scripter != nil {
+			denied = &redisFlags{scripter: scripter}
+		} else {
+			denied = &memoryFlags{until: make(map[string]time.Time)}
+		}
+	}
+
+	var idem idempotency
+	if config.IdempotencyHeader != "" {
+		if scripter != nil {
+			idem = &redisIdempotency{scripter: scripter}
+		} else {
+			idem = &memoryIdempotency{flags: make(map[string]*seenFlag)}
+		}
+	}
+
+	var memory Store
+	if config.FallbackToMemory {
+		memory = NewMemoryStore()
+	}
+
+	storeContext := func(ctx echo.Context) (context.Context, context.CancelFunc) {
+		if config.Timeout > 0 {
+			return context.WithTimeout(ctx.Request().Context(), config.Timeout)
+		}
+
+		return context.WithCancel(ctx.Request().Context())
+	}
+
+	// shape adds the global bucket to the buckets of a key and scales them
+	// with Adaptive
+	shape := func(buckets []bucket) []bucket {
+		if global != nil {
+			// Last, so keys over their own limit don't take global tokens
+			buckets = append(buckets, *global)
+		}
+
+		if adapt != nil {
+			if f := adapt.scale(); f < 1 {
+				for i, b := range buckets {
+					l := *b.limit
+					l.Rate = int64(math.Max(1, float64(l.Rate)*f))
+					l.Burst = int64(math.Max(1, float64(l.Burst)*f))
+					buckets[i].limit = &l
+				}
+			}
+		}
+
+		return buckets
+	}
+
+	// take takes n tokens from every bucket until one denies, returning the
+	// most restrictive result and the buckets the tokens were taken from.
+	// ctx is nil outside of a request, e.g. for Limiter.Check
+	take := func(c context.Context, ctx echo.Context, buckets []bucket, n int) (result *go_limiter.Result, taken []bucket, err error) {
+		n = capCost(n, buckets)
+
+		primary := store
+		if batch != nil && len(buckets) > 1 {
+			keys := make([]string, len(buckets))
+			limits := make([]*go_limiter.Limit, len(buckets))
+			for i, b := range buckets {
+				keys[i], limits[i] = b.key, b.limit
+			}
+
+			results, err := batch.AllowAllN(c, keys, limits, n)
+			if err == nil {
+				for i, r := range results {
+					if (result == nil || result.Allowed) && r.Allowed {
+						b := buckets[i]
+						b.store = store
+						taken = append(taken, b)
+					}
+
+					result = mostRestrictive(result, r)
+				}
+
+				buckets = nil
+			} else if memory == nil {
+				return nil, nil, err
+			} else {
+				logError(ctx, err)
+
+				primary = memory
+			}
+		}
+
+		for _, b := range buckets {
+			if result != nil && !result.Allowed {
+				// Already denied, only look for a longer Retry-After
+				if r, err := primary.Peek(c, b.key, b.limit); err == nil {
+					result = mostRestrictive(result, r)
+				}
+
+				continue
+			}
+
+			b.store = primary
+			r, err := b.store.AllowN(c, b.key, b.limit, n)
+			if err != nil {
+				if memory == nil {
+					return nil, nil, err
+				}
+
+				logError(ctx, err)
+
+				b.store = memory
+				r, _ = b.store.AllowN(c, b.key, b.limit, n)
+			}
+
+			if r.Allowed {
+				taken = append(taken, b)
+			}
+
+			result = mostRestrictive(result, r)
+		}
+
+		if result != nil && result.Allowed {
+			// The sliding window scripts report the window on allowances
+			// too, the middleware and Check both say there is no wait
+			result.RetryAfter = -1
+		}
+
+		return result, taken, nil
+	}
+
+	// peekAll is like take without taking tokens, the result is denied
+	// when a bucket has none left
+	peekAll := func(c context.Context, ctx echo.Context, buckets []bucket) (result *go_limiter.Result, err error) {
+		for _, b := range buckets {
+			r, err := store.Peek(c, b.key, b.limit)
+			if err != nil {
+				if memory == nil {
+					return nil, err
+				}
+
+				logError(ctx, err)
+
+				r, _ = memory.Peek(c, b.key, b.limit)
+			}
+
+			result = mostRestrictive(result, r)
+		}
+
+		return result, nil
+	}
+
+	// allow checks every limit for key, returning the most restrictive
+	// result, the buckets n tokens were taken from and whether key is
+	// banned by Escalation. A replay of an IdempotencyHeader value only
+	// peeks the buckets
+	allow := func(ctx echo.Context, key string, n int, replay bool) (result *go_limiter.Result, taken []bucket, banned bool, err error) {
+		c, cancel := storeContext(ctx)
+		defer cancel()
+
+		if config.WarmUp > 0 {
+			c = withWarmUp(c, config.WarmUp)
+		}
+
+		if config.Tracer != nil {
+			var end func(*go_limiter.Result, error)
+			c, end = config.Tracer.Start(c, key, config.Algorithm)
+			defer func() {
+				end(result, err)
+			}()
+		}
+
+		buckets, err := limits(ctx, scope(ctx, key))
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		buckets = shape(buckets)
+
+		if config.EmitPolicyHeader && !config.DisableHeaders {
+			ctx.Response().Header().Set("RateLimit-Policy", policy(buckets))
+		}
+
+		if esc != nil {
+			ban, err := esc.banned(c, scope(ctx, key))
+			if err != nil {
+				logError(ctx, err)
+			} else if ban > 0 {
+				// Banned, denied without taking tokens
+				return &go_limiter.Result{
+					Limit:      buckets[0].limit,
+					Key:        buckets[0].key,
+					RetryAfter: ban,
+					ResetAfter: ban,
+				}, nil, true, nil
+			}
+		}
+
+		if replay {
+			result, err = peekAll(c, ctx, buckets)
+		} else {
+			result, taken, err = take(c, ctx, buckets, n)
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		if esc != nil && !result.Allowed {
+			ban, err := esc.violate(c, scope(ctx, key))
+			if err != nil {
+				logError(ctx, err)
+			} else if ban > 0 {
+				banned = true
+				if ban > result.RetryAfter {
+					result.RetryAfter = ban
+				}
+			}
+		}
+
+		return result, taken, banned, nil
+	}
+
+	// refund gives the n tokens back to the buckets, best-effort
+	refund := func(ctx echo.Context, buckets []bucket, n int) {
+		c, cancel := storeContext(ctx)
+		defer cancel()
+
+		for _, b := range buckets {
+			if _, err := b.store.AllowN(c, b.key, b.limit, -n); err != nil {
+				logError(ctx, err)
+			}
+		}
+	}
+
+	l := &Limiter{
+		store:   store,
+		timeout: config.Timeout,
+		warmUp:  config.WarmUp,
+		closer:  &closer{c: config.owned},
+		esc:     esc,
+		bucket:  checkedBucket,
+		take: func(c context.Context, buckets []bucket, n int) (*go_limiter.Result, error) {
+			result, _, err := take(c, nil, shape(buckets), n)
+			return result, err
+		},
+		buckets: func(key string) []bucket {
+			return ruleBuckets(key, config.Max, config.Burst)
+		},
+		methodBuckets: func(key string) []bucket {
+			buckets := make([]bucket, 0, len(methodRules))
+			for method, rule := range methodRules {
+				l := rule.limit()
+				buckets = append(buckets, bucket{key: storeKey(prefix, config.KeySeparator, l.Algorithm, method+config.KeySeparator+key), limit: l})
+			}
+
+			return buckets
+		},
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		// failed handles an error of the limiter backend
+		failed := func(ctx echo.Context, err error) error {
+			logError(ctx, err)
+
+			if config.MetricsCollector != nil {
+				config.MetricsCollector.OnError(ctx, err)
+			}
+
+			if config.SkipOnError {
+				setHeader(ctx.Response(), config.BypassHeader, "error")
+
+				if config.OnBypass != nil {
+					config.OnBypass(ctx, err)
+				}
+
+				return next(ctx)
+			}
+
+			if config.FailClosed {
+				if !config.DisableHeaders && !config.DisableRetryAfter {
+					ctx.Response().Header().Set("Retry-After", strconv.Itoa(defaultErrorRetryAfter))
+				}
+
+				return ctx.String(config.ErrorStatusCode, config.ErrorMessage)
+			}
+
+			return config.ErrHandler(err, ctx)
+		}
+
+		return func(ctx echo.Context) error {
+			if _, ok := skipMethods[ctx.Request().Method]; ok {
+				return next(ctx)
+			}
+
+			if config.WebSocketOnly && !IsWebSocketUpgrade(ctx) {
+				return next(ctx)
+			}
+
+			if !paths.limited(ctx.Request().URL.Path) {
+				return next(ctx)
+			}
+
+			if config.SkipperE != nil {
+				skip, err := config.SkipperE(ctx)
+				if err != nil {
+					return config.ErrHandler(err, ctx)
+				}
+
+				if skip {
+					return next(ctx)
+				}
+			} else if config.Skipper(ctx) {
+				return next(ctx)
+			}
+
+			key := config.Key(ctx)
+			if key == "" {
+				switch config.OnMissingKey {
+				case MissingKeyDeny:
+					if config.MetricsCollector != nil {
+						config.MetricsCollector.OnDenied(ctx, nil)
+					}
+
+					if config.DryRun {
+						logger(ctx).Warn("rate limit: dry run, request without key would be denied")
+						return next(ctx)
+					}
+
+					return limitReached(ctx, nil)
+				case MissingKeySkip:
+					return next(ctx)
+				default:
+					key = ctx.RealIP()
+				}
+			}
+
+			setHeader(ctx.Response(), config.DebugKeyHeader, key)
+
+			if _, ok := blacklist[key]; ok {
+				// Blocked for good, so there is no Retry-After to send
+				if config.MetricsCollector != nil {
+					config.MetricsCollector.OnDenied(ctx, nil)
+				}
+
+				if config.DryRun {
+					logger(ctx).Warn(fmt.Sprintf("rate limit: dry run, blacklisted %s would be denied", key))
+					return next(ctx)
+				}
+
+				return limitReached(ctx, nil)
+			}
+
+			if _, ok := whitelist[key]; ok {
+				return next(ctx)
+			}
+
+			if guard != nil {
+				source := guard.Source(ctx)
+
+				c, cancel := storeContext(ctx)
+				exceeded, err := counted.exceeded(c, source, key)
+				cancel()
+
+				if err != nil && countedMemory != nil {
+					logError(ctx, err)
+
+					exceeded, err = countedMemory.exceeded(ctx.Request().Context(), source, key)
+				}
+
+				if err != nil {
+					return failed(ctx, err)
+				}
+
+				if exceeded && guard.Deny {
+					if config.MetricsCollector != nil {
+						config.MetricsCollector.OnDenied(ctx, nil)
+					}
+
+					if config.DryRun {
+						logger(ctx).Warn(fmt.Sprintf("rate limit: dry run, %s would be denied for too many keys", source))
+						return next(ctx)
+					}
+
+					return limitReached(ctx, nil)
+				}
+
+				if exceeded {
+					key = source
+					setHeader(ctx.Response(), config.DebugKeyHeader, key)
+				}
+			}
+
+			if conc != nil {
+				concKey := prefix + config.KeySeparator + "concurrency" + config.KeySeparator + scope(ctx, key)
+
+				slots := conc
+
+				c, cancel := storeContext(ctx)
+				id, ok, err := slots.acquire(c, concKey)
+				cancel()
+
+				if err != nil && concMemory != nil {
+					logError(ctx, err)
+
+					slots = concMemory
+					id, ok, err = slots.acquire(ctx.Request().Context(), concKey)
+				}
+
+				if err != nil {
+					return failed(ctx, err)
+				}
+
+				if !ok {
+					if config.MetricsCollector != nil {
+						config.MetricsCollector.OnDenied(ctx, nil)
+					}
+
+					if config.DryRun {
+						logger(ctx).Warn(fmt.Sprintf("rate limit: dry run, %s would be denied for concurrency", key))
+						return next(ctx)
+					}
+
+					return limitReached(ctx, nil)
+				}
+
+				defer func() {
+					// Not the request context, it's gone once the client is
+					c, cancel := context.WithCancel(context.Background())
+					if config.Timeout > 0 {
+						c, cancel = context.WithTimeout(context.Background(), config.Timeout)
+					}
+					defer cancel()
+
+					if err := slots.release(c, concKey, id); err != nil {
+						logError(ctx, err)
+					}
+				}()
+			}
+
+			seenKey, replay := "", false
+			if idem != nil {
+				if v := headerValue(ctx.Request().Header, config.IdempotencyHeader); v != "" {
+					seenKey = prefix + config.KeySeparator + "idempotency" + config.KeySeparator + scope(ctx, key) + config.KeySeparator + hash(v)
+
+					c, cancel := storeContext(ctx)
+					seen, err := idem.seen(c, seenKey, config.IdempotencyMaxReplays)
+					cancel()
+
+					if err != nil {
+						logError(ctx, err)
+					}
+
+					// A retry of a request that already took its tokens
+					replay = seen
+				}
+			}
+
+			n := cost(ctx)
+
+			start := time.Now()
+			result, buckets, banned, err := allow(ctx, key, n, replay)
+			if config.MetricsCollector != nil {
+				config.MetricsCollector.ObserveLatency(time.Since(start))
+			}
+
+			if err != nil {
+				return failed(ctx, err)
+			}
+
+			// No wait lifts a ban, banned keys are denied in any mode
+			delayed := !result.Allowed && config.Mode == DelayMode && !banned
+			if delayed {
+				wait := result.RetryAfter
+				if wait > config.MaxDelay {
+					wait = config.MaxDelay
+				}
+
+				if !sleep(ctx, wait, config.MaxDelay) {
+					// The client is gone, there is nobody to answer
+					return nil
+				}
+
+				if delays != nil {
+					delays.ObserveDelay(ctx, wait)
+				}
+
+				// Served like an allowed request from here on, with its
+				// headers and callbacks
+				result = delayedResult(result, wait)
+			}
+
+			// Check if hits exceed the max
+			if !result.Allowed {
+				// The flag of OnFirstDenied lives until the key is allowed
+				// again, the jitter only delays the client
+				retryAfter := result.RetryAfter
+
+				if config.RetryAfterJitter > 0 {
+					// Spread the retries of clients denied at the same time
+					result.RetryAfter += time.Duration(rand.Int63n(int64(config.RetryAfterJitter) + 1))
+				}
+
+				if !config.DisableHeaders {
+					config.HeaderWriter(ctx, result, limitRule(result.Limit))
+				}
+
+				if config.MetricsCollector != nil {
+					config.MetricsCollector.OnDenied(ctx, result)
+				}
+
+				if utilized != nil {
+					utilized.ObserveUtilization(ctx, utilization(result))
+				}
+
+				if config.OnDenied != nil {
+					config.OnDenied(ctx, result)
+				}
+
+				if denied != nil {
+					c, cancel := storeContext(ctx)
+					first, err := denied.mark(c, prefix+config.KeySeparator+"denied"+config.KeySeparator+scope(ctx, key), retryAfter)
+					cancel()
+
+					if err != nil {
+						logError(ctx, err)
+					} else if first {
+						config.OnFirstDenied(ctx, result)
+					}
+				}
+
+				if config.DryRun {
+					logger(ctx).Warn(fmt.Sprintf("rate limit: dry run, %s would be denied", key))
+					ctx.Set(config.ContextKey, result)
+					return next(ctx)
+				}
+
+				// Call Handler func, next is never called from here on, the
+				// limited request ends with the Handler's response
+				return limitReached(ctx, result)
+			}
+
+			if seenKey != "" && !replay && !delayed {
+				// Only now, so failed, denied and delayed requests, and
+				// their duplicates still being checked, take tokens on retry
+				c, cancel := storeContext(ctx)
+				if err := idem.see(c, seenKey, config.IdempotencyWindow); err != nil {
+					logError(ctx, err)
+				}
+				cancel()
+			}
+
+			// We can continue, update RateLimit headers
+			if !config.DisableHeaders {
+				config.HeaderWriter(ctx, result, limitRule(result.Limit))
+			}
+
+			if config.MetricsCollector != nil {
+				config.MetricsCollector.OnAllowed(ctx, result)
+			}
+
+			if utilized != nil {
+				utilized.ObserveUtilization(ctx, utilization(result))
+			}
+
+			if config.OnAllowed != nil {
+				config.OnAllowed(ctx, result)
+			}
+
+			ctx.Set(config.ContextKey, result)
+
+			if config.RefundOnPanic {
+				defer func() {
+					if r := recover(); r != nil {
+						refund(ctx, buckets, n)
+						panic(r)
+					}
+				}()
+			}
+
+			err = next(ctx)
+			if adapt != nil {
+				adapt.record(ResponseStatus(ctx, err) >= http.StatusInternalServerError)
+			}
+
+			if (config.SkipFunc != nil && config.SkipFunc(ctx, err)) || (config.RefundOn != nil && config.RefundOn(ctx)) {
+				refund(ctx, buckets, n)
+			}
+
+			return err
+		}
+	}, l, nil
+}
+
+// ResponseStatus returns the status code of the response next produced,
+// either from the error it returned or from the written response.
+// Useful for SkipFunc
+func ResponseStatus(ctx echo.Context, err error) int {
+	if err == nil {
+		return ctx.Response().Status
+	}
+
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+
+	return http.StatusInternalServerError
+}
+
+// routePath is the registered route of ctx. For requests no route matched
+// echo sets the raw path, they get "unmatched" so scanning random paths
+// can't create a bucket per path
+func routePath(ctx echo.Context) string {
+	if h := ctx.Handler(); h == nil || reflect.ValueOf(h).Pointer() == reflect.ValueOf(echo.NotFoundHandler).Pointer() {
+		return "unmatched"
+	}
+
+	return ctx.Path()
+}
+
+// utilization is the share of its bucket result used, the size of a gcra
+// bucket is its burst
+func utilization(result *go_limiter.Result) float64 {
+	if !result.Allowed {
+		return 1
+	}
+
+	size := result.Limit.Rate
+	if result.Limit.Algorithm == go_limiter.GCRAAlgorithm && result.Limit.Burst > 0 {
+		size = result.Limit.Burst
+	}
+
+	if size <= 0 {
+		return 1
+	}
+
+	return math.Min(1, math.Max(0, 1-float64(result.Remaining)/float64(size)))
+}
+
+// mostRestrictive returns the result that limits the client the most: a
+// denial over an allowance, the longest RetryAfter among denials and the
+// lowest Remaining among allowances
+func mostRestrictive(a, b *go_limiter.Result) *go_limiter.Result {
+	switch {
+	case a == nil:
+		return b
+	case a.Allowed != b.Allowed:
+		if !a.Allowed {
+			return a
+		}
+
+		return b
+	case !a.Allowed:
+		if b.RetryAfter > a.RetryAfter {
+			return b
+		}
+
+		return a
+	default:
+		if b.Remaining < a.Remaining {
+			return b
+		}
+
+		return a
+	}
+}
+
+// sleep waits for d, at most max, reporting false when the request was
+// canceled first
+func sleep(ctx echo.Context, d, max time.Duration) bool {
+	if d > max {
+		d = max
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Request().Context().Done():
+		return false
+	}
+}
+
+// delayedResult is the result of a request DelayMode served after wait,
+// allowed with no tokens left, it took none
+func delayedResult(result *go_limiter.Result, wait time.Duration) *go_limiter.Result {
+	r := *result
+	r.Allowed = true
+	r.Remaining = 0
+	r.RetryAfter = -1
+
+	if r.ResetAfter -= wait; r.ResetAfter < 0 {
+		r.ResetAfter = 0
+	}
+
+	return &r
+}
+
+// policy returns the RateLimit-Policy of buckets
+// https://datatracker.ietf.org/doc/html/draft-ietf-httpapi-ratelimit-headers
+func policy(buckets []bucket) string {
+	policies := make([]string, len(buckets))
+	for i, b := range buckets {
+		policies[i] = strconv.FormatInt(b.limit.Rate, 10) + ";w=" + strconv.FormatInt(seconds(b.limit.Period), 10)
+	}
+
+	return strings.Join(policies, ", ")
+}
+
+// setHeader sets the header unless its name is empty
+func setHeader(res *echo.Response, name, value string) {
+	if name != "" {
+		res.Header().Set(name, value)
+	}
+}
+
+// seconds rounds d up to whole seconds, so a client never retries too early
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return int64(math.Ceil(d.Seconds()))
 }
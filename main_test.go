@@ -0,0 +1,1929 @@
+package echo_limiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/shareed2k/echo_limiter/testutil"
+	"github.com/shareed2k/go_limiter"
+)
+
+// newRedis returns a miniredis server and a client of it, both closed
+// when the test ends
+func newRedis(t testing.TB) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return mr, client
+}
+
+// newServer returns an echo server behind the middleware of config,
+// answering "ok" on every path and method
+func newServer(t testing.TB, config Config) *echo.Echo {
+	t.Helper()
+
+	m, err := NewWithConfigE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.Any("/*", func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+
+	return e
+}
+
+// serve sends a request to e, headers are name and value pairs
+func serve(e *echo.Echo, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// codes serves n GET requests to e and returns their status codes
+func codes(e *echo.Echo, n int, headers ...string) []int {
+	codes := make([]int, n)
+	for i := range codes {
+		codes[i] = serve(e, http.MethodGet, "/", headers...).Code
+	}
+
+	return codes
+}
+
+func TestUniversalClients(t *testing.T) {
+	mr, _ := newRedis(t)
+
+	tests := []struct {
+		name   string
+		client redis.UniversalClient
+	}{
+		{"client", redis.NewClient(&redis.Options{Addr: mr.Addr()})},
+		{"universal", redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{mr.Addr()}})},
+		{"ring", redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"a": mr.Addr()}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.client.Close()
+
+			e := newServer(t, Config{Rediser: tt.client, Max: 2, Prefix: tt.name})
+
+			want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+			if got := codes(e, 3); !reflect.DeepEqual(got, want) {
+				t.Errorf("codes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// ctxKey is a request context key seen by contextStore
+type ctxKey struct{}
+
+// contextStore records the ctxKey value of the contexts it's called with
+type contextStore struct {
+	Store
+	values []interface{}
+}
+
+func (s *contextStore) AllowN(ctx context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error) {
+	s.values = append(s.values, ctx.Value(ctxKey{}))
+	return s.Store.AllowN(ctx, key, limit, n)
+}
+
+func TestRequestContext(t *testing.T) {
+	store := &contextStore{Store: NewMemoryStore()}
+	e := newServer(t, Config{Store: store})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(store.values) != 1 || store.values[0] != "request" {
+		t.Errorf("store saw %v, want the request context", store.values)
+	}
+}
+
+func TestRetryAfterDelta(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{Rediser: client, Max: 1, Burst: 1, Period: time.Minute, Algorithm: algorithm})
+		codes(e, 1)
+
+		rec := serve(e, http.MethodGet, "/")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("algorithm %d: code = %d, want 429", algorithm, rec.Code)
+		}
+
+		retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || retryAfter > 60 {
+			t.Errorf("algorithm %d: Retry-After = %q, want delta-seconds up to 60", algorithm, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestResetHeader(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		delta    bool
+		min, max int64
+	}{
+		{"epoch", false, 1059, 1060},
+		{"delta", true, 59, 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{
+				Store:        NewMemoryStore(),
+				Max:          1,
+				Burst:        1,
+				Period:       time.Minute,
+				ResetAsDelta: tt.delta,
+				Now:          func() time.Time { return now },
+			})
+
+			reset, err := strconv.ParseInt(serve(e, http.MethodGet, "/").Header().Get(defaultResetHeader), 10, 64)
+			if err != nil || reset < tt.min || reset > tt.max {
+				t.Errorf("reset = %d (%v), want %d to %d", reset, err, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestHeaderNames(t *testing.T) {
+	e := newServer(t, Config{
+		Store:           NewMemoryStore(),
+		Max:             3,
+		LimitHeader:     "RateLimit-Limit",
+		RemainingHeader: "RateLimit-Remaining",
+		ResetHeader:     "RateLimit-Reset",
+	})
+
+	h := serve(e, http.MethodGet, "/").Header()
+	if h.Get("RateLimit-Limit") != "3" || h.Get("RateLimit-Remaining") != "2" || h.Get("RateLimit-Reset") == "" {
+		t.Errorf("headers = %v, want the custom names", h)
+	}
+
+	if h.Get(defaultLimitHeader) != "" {
+		t.Errorf("%s set along the custom name", defaultLimitHeader)
+	}
+}
+
+func TestDisableHeaders(t *testing.T) {
+	e := newServer(t, Config{Store: NewMemoryStore(), Max: 1, Burst: 1, DisableHeaders: true})
+
+	for _, rec := range []*httptest.ResponseRecorder{serve(e, http.MethodGet, "/"), serve(e, http.MethodGet, "/")} {
+		for _, name := range []string{defaultLimitHeader, defaultRemainingHeader, defaultResetHeader, "Retry-After"} {
+			if v := rec.Header().Get(name); v != "" {
+				t.Errorf("code %d: %s = %q, want none", rec.Code, name, v)
+			}
+		}
+	}
+}
+
+func TestSharedPrefix(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name             string
+		prefixA, prefixB string
+		want             int
+	}{
+		{"shared", "api", "api", http.StatusTooManyRequests},
+		{"separate", "login", "search", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			ok := func(ctx echo.Context) error { return ctx.String(http.StatusOK, "ok") }
+			e.GET("/a", ok, NewWithConfig(Config{Rediser: client, Max: 1, Prefix: tt.prefixA}))
+			e.GET("/b", ok, NewWithConfig(Config{Rediser: client, Max: 1, Prefix: tt.prefixB}))
+
+			serve(e, http.MethodGet, "/a")
+			if got := serve(e, http.MethodGet, "/b").Code; got != tt.want {
+				t.Errorf("code = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludePathCounts(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name        string
+		includePath bool
+		want        []int
+	}{
+		{"included", true, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusTooManyRequests}},
+		{"shared key", false, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One Key func for both routes, the keys only differ by path
+			m := NewWithConfig(Config{Rediser: client, Max: 1, Prefix: tt.name, IncludePath: tt.includePath})
+
+			e := echo.New()
+			ok := func(ctx echo.Context) error { return ctx.String(http.StatusOK, "ok") }
+			e.GET("/login", ok, m)
+			e.GET("/api", ok, m)
+
+			got := []int{
+				serve(e, http.MethodGet, "/login").Code,
+				serve(e, http.MethodGet, "/login").Code,
+				serve(e, http.MethodGet, "/api").Code,
+				serve(e, http.MethodGet, "/api").Code,
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxFunc(t *testing.T) {
+	e := newServer(t, Config{
+		Store: NewMemoryStore(),
+		Max:   1,
+		MaxFunc: func(ctx echo.Context) int {
+			if ctx.Request().Header.Get("Plan") == "pro" {
+				return 3
+			}
+
+			return 0
+		},
+		Key: func(ctx echo.Context) string {
+			return ctx.Request().Header.Get("Plan")
+		},
+	})
+
+	tests := []struct {
+		plan string
+		want []int
+	}{
+		{"free", []int{http.StatusOK, http.StatusTooManyRequests}},
+		{"pro", []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+	}
+
+	for _, tt := range tests {
+		if got := codes(e, len(tt.want), "Plan", tt.plan); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("plan %s: codes = %v, want %v", tt.plan, got, tt.want)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{
+			Rediser:   client,
+			Max:       5,
+			Burst:     5,
+			Algorithm: algorithm,
+			Cost:      func(echo.Context) int { return 2 },
+		})
+
+		var (
+			got       []int
+			remaining []string
+		)
+		for i := 0; i < 3; i++ {
+			rec := serve(e, http.MethodGet, "/")
+			got = append(got, rec.Code)
+			remaining = append(remaining, rec.Header().Get(defaultRemainingHeader))
+		}
+
+		if want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}; !reflect.DeepEqual(got, want) {
+			t.Errorf("algorithm %d: codes = %v, want %v", algorithm, got, want)
+		}
+
+		if want := []string{"3", "1"}; !reflect.DeepEqual(remaining[:2], want) {
+			t.Errorf("algorithm %d: remaining = %v, want %v", algorithm, remaining[:2], want)
+		}
+	}
+}
+
+func TestFallbackToMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"limits", Config{}},
+		{"concurrency max", Config{ConcurrencyMax: 1}},
+		{"cardinality", Config{Cardinality: &Cardinality{Max: 1}}},
+		{"cardinality deny", Config{Cardinality: &Cardinality{Max: 1, Deny: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, client := newRedis(t)
+
+			tt.config.Rediser = client
+			tt.config.Max = 1
+			tt.config.FallbackToMemory = true
+			tt.config.Logger = discard{}
+			e := newServer(t, tt.config)
+			mr.Close()
+
+			want := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+			if got := codes(e, 3); !reflect.DeepEqual(got, want) {
+				t.Errorf("codes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// discard is a Logger dropping everything
+type discard struct{}
+
+func (discard) Error(...interface{}) {}
+func (discard) Warn(...interface{})  {}
+
+func TestWhitelist(t *testing.T) {
+	store := testutil.AllowThenDeny(0)
+	e := newServer(t, Config{Store: store, Whitelist: []string{"192.0.2.1"}})
+
+	rec := serve(e, http.MethodGet, "/")
+	if rec.Code != http.StatusOK || rec.Header().Get(defaultLimitHeader) != "" {
+		t.Errorf("code %d headers %v, want 200 without headers", rec.Code, rec.Header())
+	}
+
+	if calls := store.Calls(); len(calls) != 0 {
+		t.Errorf("store calls = %v, want none", calls)
+	}
+}
+
+func TestBlacklist(t *testing.T) {
+	store := testutil.AllowAll()
+	e := newServer(t, Config{
+		Store:     store,
+		Blacklist: []string{"bad"},
+		Key:       func(ctx echo.Context) string { return ctx.Request().Header.Get("Key") },
+	})
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"bad", http.StatusTooManyRequests},
+		{"good", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := serve(e, http.MethodGet, "/", "Key", tt.key).Code; got != tt.want {
+			t.Errorf("key %s: code = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+
+	if calls := store.Calls(); len(calls) != 1 || calls[0].Key == storeKey(DefaultKeyPrefix, defaultKeySeparator, SlidingWindowAlgorithm, "bad") {
+		t.Errorf("store calls = %v, want the good key alone", calls)
+	}
+}
+
+func TestStackedLimits(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		limits []LimitRule
+		want   []int
+	}{
+		{"first", []LimitRule{{Max: 1, Period: time.Second}, {Max: 5, Period: time.Hour}}, []int{http.StatusOK, http.StatusTooManyRequests}},
+		{"second", []LimitRule{{Max: 5, Period: time.Second}, {Max: 2, Period: time.Hour}}, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{Rediser: client, Prefix: tt.name, Limits: tt.limits})
+			if got := codes(e, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	e := newServer(t, Config{Store: NewMemoryStore(), Max: 1, Burst: 1, Period: time.Minute, MessageJSON: true})
+	codes(e, 1)
+
+	rec := serve(e, http.MethodGet, "/")
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("content type = %q, want JSON", ct)
+	}
+
+	var body struct {
+		Message    string `json:"message"`
+		RetryAfter int64  `json:"retry_after"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Message != defaultMessage || body.RetryAfter < 1 || body.RetryAfter > 60 {
+		t.Errorf("body = %+v, want the message and a retry after up to 60s", body)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		template    bool
+		want        string
+	}{
+		{"default", "", false, echo.MIMETextPlainCharsetUTF8},
+		{"custom", "text/plain; charset=us-ascii", false, "text/plain; charset=us-ascii"},
+		{"template", "text/html", true, "text/html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{Store: NewMemoryStore(), Max: 1, Period: time.Minute, ContentType: tt.contentType, MessageTemplate: tt.template})
+			codes(e, 1)
+
+			rec := serve(e, http.MethodGet, "/")
+			if got := rec.Header().Get(echo.HeaderContentType); rec.Code != http.StatusTooManyRequests || got != tt.want {
+				t.Errorf("code %d content type %q, want %d %q", rec.Code, got, http.StatusTooManyRequests, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleHeader(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+		limits []LimitRule
+		header string
+		want   string
+	}{
+		{"first rule", Config{Store: NewMemoryStore()}, []LimitRule{{Max: 1, Period: time.Minute}, {Max: 5, Period: time.Second}}, "", "1/1m"},
+		{"second rule", Config{Store: NewMemoryStore()}, []LimitRule{{Max: 5, Period: time.Minute}, {Max: 1, Period: time.Second}}, "", "1/1s"},
+		{"both exceeded", Config{Store: NewMemoryStore()}, []LimitRule{{Max: 1, Period: time.Second}, {Max: 1, Period: time.Minute}}, "", "1/1m"},
+		{"both exceeded redis", Config{Rediser: client}, []LimitRule{{Max: 1, Period: time.Second}, {Max: 1, Period: time.Minute}}, "", "1/1m"},
+		{"custom header", Config{Store: NewMemoryStore()}, []LimitRule{{Max: 1, Period: time.Minute}, {Max: 5, Period: time.Second}}, "X-Rule", "1/1m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Limits = tt.limits
+			tt.config.RuleHeader = tt.header
+			e := newServer(t, tt.config)
+
+			header := tt.header
+			if header == "" {
+				header = defaultRuleHeader
+			}
+
+			if got := serve(e, http.MethodGet, "/").Header().Get(header); got != "" {
+				t.Errorf("allowed %s = %q, want none", header, got)
+			}
+
+			rec := serve(e, http.MethodGet, "/")
+			if got := rec.Header().Get(header); rec.Code != http.StatusTooManyRequests || got != tt.want {
+				t.Errorf("code %d %s %q, want %d %q", rec.Code, header, got, http.StatusTooManyRequests, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitReachedHandler(t *testing.T) {
+	var got *go_limiter.Result
+	e := newServer(t, Config{
+		Store:  NewMemoryStore(),
+		Max:    1,
+		Burst:  1,
+		Period: time.Minute,
+		LimitReachedHandler: func(ctx echo.Context, result *go_limiter.Result) error {
+			got = result
+			return ctx.String(http.StatusTeapot, "slow down")
+		},
+	})
+	codes(e, 1)
+
+	rec := serve(e, http.MethodGet, "/")
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "slow down" {
+		t.Errorf("response %d %q, want the handler's", rec.Code, rec.Body.String())
+	}
+
+	if got == nil || got.Allowed || got.RetryAfter <= 0 || got.Limit.Rate != 1 {
+		t.Errorf("result = %+v, want the denial", got)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	var allowed, denied []int64
+	e := newServer(t, Config{
+		Store: NewMemoryStore(),
+		Max:   2,
+		Burst: 2,
+		OnAllowed: func(_ echo.Context, result *go_limiter.Result) {
+			allowed = append(allowed, result.Remaining)
+		},
+		OnDenied: func(_ echo.Context, result *go_limiter.Result) {
+			denied = append(denied, result.Remaining)
+		},
+	})
+	codes(e, 3)
+
+	if !reflect.DeepEqual(allowed, []int64{1, 0}) || len(denied) != 1 {
+		t.Errorf("allowed %v denied %v, want 2 allowed and 1 denied", allowed, denied)
+	}
+}
+
+func TestMissingClient(t *testing.T) {
+	if _, err := NewE(nil); err == nil {
+		t.Error("NewE(nil) returned no error")
+	}
+
+	if _, err := NewWithConfigE(Config{Max: 1}); err == nil {
+		t.Error("NewWithConfigE without a client returned no error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("NewWithConfig without a client didn't panic")
+		}
+	}()
+	NewWithConfig(Config{})
+}
+
+func TestKeyParts(t *testing.T) {
+	header := func(name string) func(echo.Context) string {
+		return func(ctx echo.Context) string { return ctx.Request().Header.Get(name) }
+	}
+
+	e := newServer(t, Config{
+		Store:          NewMemoryStore(),
+		Max:            1,
+		KeyParts:       []func(echo.Context) string{header("User"), header("Route")},
+		DebugKeyHeader: "X-RateLimit-Key",
+	})
+
+	tests := []struct {
+		user, route string
+		wantKey     string
+		wantCode    int
+	}{
+		{"bob", "/a", "bob:/a", http.StatusOK},
+		{"bob", "/b", "bob:/b", http.StatusOK},
+		{"bob", "/a", "bob:/a", http.StatusTooManyRequests},
+		{"", "/a", "/a", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := serve(e, http.MethodGet, "/", "User", tt.user, "Route", tt.route)
+		if got := rec.Header().Get("X-RateLimit-Key"); got != tt.wantKey || rec.Code != tt.wantCode {
+			t.Errorf("%s %s: key %q code %d, want %q %d", tt.user, tt.route, got, rec.Code, tt.wantKey, tt.wantCode)
+		}
+	}
+}
+
+func TestDebugKeyHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"X-RateLimit-Key", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		e := newServer(t, Config{Store: NewMemoryStore(), DebugKeyHeader: tt.header})
+		if got := serve(e, http.MethodGet, "/").Header().Get("X-RateLimit-Key"); got != tt.want {
+			t.Errorf("header %q: key = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		separator string
+		want      string
+	}{
+		{"default", "", "", "echo_limiter:sliding_window:192.0.2.1"},
+		{"namespace", "svcA", "", "svcA:echo_limiter:sliding_window:192.0.2.1"},
+		{"separator", "svcA", "|", "svcA|echo_limiter|sliding_window|192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, client := newRedis(t)
+			e := newServer(t, Config{Rediser: client, Namespace: tt.namespace, KeySeparator: tt.separator})
+			codes(e, 1)
+
+			if got := mr.Keys(); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("keys = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterJitter(t *testing.T) {
+	tests := []struct {
+		jitter   time.Duration
+		min, max int
+	}{
+		{0, 60, 60},
+		{30 * time.Second, 60, 90},
+	}
+
+	for _, tt := range tests {
+		e := newServer(t, Config{
+			Store:            testutil.AllowThenDeny(0),
+			Period:           time.Minute,
+			RetryAfterJitter: tt.jitter,
+		})
+
+		for i := 0; i < 20; i++ {
+			retryAfter, err := strconv.Atoi(serve(e, http.MethodGet, "/").Header().Get("Retry-After"))
+			if err != nil || retryAfter < tt.min || retryAfter > tt.max {
+				t.Fatalf("jitter %v: Retry-After = %d (%v), want %d to %d", tt.jitter, retryAfter, err, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestNow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	e := newServer(t, Config{
+		Store:  testutil.AllowThenDeny(1),
+		Period: time.Minute,
+		Now:    func() time.Time { return now },
+	})
+
+	tests := []struct {
+		reset, retryAfter string
+	}{
+		{"1060", ""},
+		{"1060", "60"},
+	}
+
+	for i, tt := range tests {
+		rec := serve(e, http.MethodGet, "/")
+		if got := rec.Header().Get(defaultResetHeader); got != tt.reset {
+			t.Errorf("request %d: reset = %q, want %q", i, got, tt.reset)
+		}
+
+		if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("request %d: Retry-After = %q, want %q", i, got, tt.retryAfter)
+		}
+	}
+}
+
+func TestSubSecondPeriod(t *testing.T) {
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{Store: NewMemoryStore(), Max: 2, Period: 200 * time.Millisecond, Algorithm: algorithm})
+
+		want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+		if got := codes(e, 3); !reflect.DeepEqual(got, want) {
+			t.Errorf("algorithm %d: codes = %v, want %v", algorithm, got, want)
+		}
+
+		if got := serve(e, http.MethodGet, "/").Header().Get("Retry-After"); got != "1" {
+			t.Errorf("algorithm %d: Retry-After = %q, want it rounded up to 1", algorithm, got)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		if got := serve(e, http.MethodGet, "/").Code; got != http.StatusOK {
+			t.Errorf("algorithm %d: code after the period = %d, want 200", algorithm, got)
+		}
+	}
+}
+
+// newStatusServer returns an echo server behind the middleware of config,
+// writing the status given by the "status" query parameter, or returning
+// it as an error with "error" set
+func newStatusServer(t testing.TB, config Config) *echo.Echo {
+	t.Helper()
+
+	m, err := NewWithConfigE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.Any("/*", func(ctx echo.Context) error {
+		status, err := strconv.Atoi(ctx.QueryParam("status"))
+		if err != nil {
+			status = http.StatusOK
+		}
+
+		if ctx.QueryParam("error") != "" {
+			return echo.NewHTTPError(status)
+		}
+
+		return ctx.NoContent(status)
+	})
+
+	return e
+}
+
+func TestSkipFunc(t *testing.T) {
+	e := newStatusServer(t, Config{
+		Store: NewMemoryStore(),
+		Max:   2,
+		SkipFunc: func(ctx echo.Context, err error) bool {
+			return ResponseStatus(ctx, err) < http.StatusBadRequest
+		},
+	})
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"status=200", http.StatusOK},
+		{"status=200", http.StatusOK},
+		{"status=200", http.StatusOK},
+		{"status=401&error=1", http.StatusUnauthorized},
+		{"status=200", http.StatusOK},
+		{"status=403", http.StatusForbidden},
+		{"status=200", http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		if got := serve(e, http.MethodPost, "/login?"+tt.query).Code; got != tt.want {
+			t.Errorf("request %d: code = %d, want %d", i, got, tt.want)
+		}
+	}
+}
+
+func TestRefundOn(t *testing.T) {
+	e := newStatusServer(t, Config{
+		Store: NewMemoryStore(),
+		Max:   3,
+		RefundOn: func(ctx echo.Context) bool {
+			return ctx.Response().Status == http.StatusBadGateway
+		},
+	})
+
+	tests := []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "2"},
+		{http.StatusBadGateway, "1"},
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+	}
+
+	for i, tt := range tests {
+		rec := serve(e, http.MethodGet, "/?status="+strconv.Itoa(tt.status))
+		if got := rec.Header().Get(defaultRemainingHeader); got != tt.remaining {
+			t.Errorf("request %d: remaining = %q, want %q", i, got, tt.remaining)
+		}
+	}
+}
+
+func TestMethodLimits(t *testing.T) {
+	e := newServer(t, Config{
+		Store:        NewMemoryStore(),
+		Max:          3,
+		MethodLimits: map[string]LimitRule{http.MethodPost: {Max: 1}},
+	})
+
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{http.MethodPost, http.StatusOK},
+		{http.MethodPost, http.StatusTooManyRequests},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusTooManyRequests},
+		{http.MethodPost, http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		if got := serve(e, tt.method, "/").Code; got != tt.want {
+			t.Errorf("request %d %s: code = %d, want %d", i, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRedisError(t *testing.T) {
+	mr, client := newRedis(t)
+	e := newServer(t, Config{Rediser: client})
+	mr.Close()
+
+	rec := serve(e, http.MethodGet, "/")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("code %d Retry-After %q, want 503 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+// hanging returns the address of a listener accepting connections and
+// never answering, like a stuck redis
+func hanging(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	t.Cleanup(func() {
+		ln.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	return ln.Addr().String()
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		skipOnError bool
+		want        int
+	}{
+		{"error", false, http.StatusServiceUnavailable},
+		{"skip on error", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The read timeout of the client is far longer than Timeout
+			client := redis.NewClient(&redis.Options{Addr: hanging(t), ReadTimeout: 10 * time.Second})
+			defer client.Close()
+
+			var got error
+			e := newServer(t, Config{
+				Rediser:     client,
+				Timeout:     100 * time.Millisecond,
+				SkipOnError: tt.skipOnError,
+				Logger:      discard{},
+				ErrHandler: func(err error, ctx echo.Context) error {
+					got = err
+					return ctx.NoContent(http.StatusServiceUnavailable)
+				},
+			})
+
+			start := time.Now()
+			rec := serve(e, http.MethodGet, "/")
+			elapsed := time.Since(start)
+
+			if rec.Code != tt.want || elapsed > time.Second {
+				t.Errorf("code %d after %v, want %d within the timeout", rec.Code, elapsed, tt.want)
+			}
+
+			var netErr net.Error
+			if tt.skipOnError && got != nil {
+				t.Errorf("ErrHandler called with %v, want SkipOnError to skip it", got)
+			} else if !tt.skipOnError && !errors.Is(got, context.DeadlineExceeded) && !(errors.As(got, &netErr) && netErr.Timeout()) {
+				t.Errorf("ErrHandler error = %v, want a deadline error", got)
+			}
+		})
+	}
+}
+
+// failing is a Store whose calls all fail
+type failing struct{ Store }
+
+func (failing) AllowN(context.Context, string, *go_limiter.Limit, int) (*go_limiter.Result, error) {
+	return nil, errors.New("down")
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		message     string
+		wantStatus  int
+		wantMessage string
+	}{
+		{"defaults", 0, "", http.StatusServiceUnavailable, defaultErrorMessage},
+		{"custom", http.StatusBadGateway, "try later", http.StatusBadGateway, "try later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{Store: failing{}, ErrorStatusCode: tt.status, ErrorMessage: tt.message})
+			rec := serve(e, http.MethodGet, "/")
+
+			var body struct{ Message string }
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.wantStatus || body.Message != tt.wantMessage {
+				t.Errorf("got %d %q, want %d %q", rec.Code, body.Message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestContextKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		get        string
+		wantResult bool
+	}{
+		{"default", Config{}, DefaultContextKey, true},
+		{"custom", Config{ContextKey: "quota"}, "quota", true},
+		{"whitelisted", Config{Whitelist: []string{"192.0.2.1"}}, DefaultContextKey, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = NewMemoryStore()
+			tt.config.Max = 3
+
+			m, err := NewWithConfigE(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				result *go_limiter.Result
+				ok     bool
+			)
+
+			e := echo.New()
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error {
+				result, ok = ctx.Get(tt.get).(*go_limiter.Result)
+				return nil
+			})
+			serve(e, http.MethodGet, "/")
+
+			if ok != tt.wantResult {
+				t.Fatalf("result set %v, want %v", ok, tt.wantResult)
+			}
+
+			if ok && (!result.Allowed || result.Remaining != 2) {
+				t.Errorf("result = %+v, want allowed with 2 remaining", result)
+			}
+		})
+	}
+}
+
+func TestSkipperE(t *testing.T) {
+	tests := []struct {
+		name      string
+		skip      bool
+		err       error
+		wantCode  int
+		wantCalls int
+	}{
+		{"skip", true, nil, http.StatusOK, 0},
+		{"limit", false, nil, http.StatusOK, 1},
+		{"error", true, errors.New("cache down"), http.StatusServiceUnavailable, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testutil.AllowAll()
+			e := newServer(t, Config{
+				Store: store,
+				SkipperE: func(echo.Context) (bool, error) {
+					return tt.skip, tt.err
+				},
+			})
+
+			if got := serve(e, http.MethodGet, "/").Code; got != tt.wantCode {
+				t.Errorf("code = %d, want %d", got, tt.wantCode)
+			}
+
+			if got := len(store.Calls()); got != tt.wantCalls {
+				t.Errorf("store calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResetAltHeader(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	tests := []struct {
+		name      string
+		delta     bool
+		reset     string
+		alternate string
+	}{
+		{"epoch", false, "1060", "60"},
+		{"delta", true, "60", "1060"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{
+				Store:          testutil.AllowAll(),
+				Period:         time.Minute,
+				ResetAsDelta:   tt.delta,
+				ResetAltHeader: "X-RateLimit-Reset-After",
+				Now:            func() time.Time { return now },
+			})
+
+			header := serve(e, http.MethodGet, "/").Header()
+			if got := header.Get(defaultResetHeader); got != tt.reset {
+				t.Errorf("reset = %q, want %q", got, tt.reset)
+			}
+
+			if got := header.Get("X-RateLimit-Reset-After"); got != tt.alternate {
+				t.Errorf("alternate reset = %q, want %q", got, tt.alternate)
+			}
+		})
+	}
+}
+
+// providerFunc adapts a func to LimitProvider
+type providerFunc func(echo.Context) (LimitRule, error)
+
+func (f providerFunc) Limit(ctx echo.Context) (LimitRule, error) {
+	return f(ctx)
+}
+
+// plans are the rules of the "Plan" header served by planProvider
+var plans = map[string]LimitRule{
+	"free":    {Max: 1},
+	"pro":     {Max: 3},
+	"invalid": {Max: -1},
+}
+
+// planProvider returns the rule of the request's "Plan" header, failing
+// for unknown plans
+func planProvider(ctx echo.Context) (LimitRule, error) {
+	rule, ok := plans[ctx.Request().Header.Get("Plan")]
+	if !ok {
+		return LimitRule{}, errors.New("unknown plan")
+	}
+
+	return rule, nil
+}
+
+func TestLimitProvider(t *testing.T) {
+	tests := []struct {
+		plan string
+		want []int
+	}{
+		{"free", []int{http.StatusOK, http.StatusTooManyRequests}},
+		{"pro", []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{"invalid", []int{http.StatusServiceUnavailable}},
+		{"unknown", []int{http.StatusServiceUnavailable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.plan, func(t *testing.T) {
+			e := newServer(t, Config{
+				Store:         NewMemoryStore(),
+				Max:           100,
+				LimitProvider: providerFunc(planProvider),
+			})
+
+			if got := codes(e, len(tt.want), "Plan", tt.plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeniedHeaders(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{Rediser: client, Max: 1, Burst: 1, Period: time.Minute, Algorithm: algorithm})
+		codes(e, 1)
+
+		rec := serve(e, http.MethodGet, "/")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("algorithm %d: code = %d, want 429", algorithm, rec.Code)
+		}
+
+		want := map[string]string{defaultLimitHeader: "1", defaultRemainingHeader: "0"}
+		for name, value := range want {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("algorithm %d: %s = %q, want %q", algorithm, name, got, value)
+			}
+		}
+
+		for _, name := range []string{defaultResetHeader, "Retry-After"} {
+			if rec.Header().Get(name) == "" {
+				t.Errorf("algorithm %d: %s missing", algorithm, name)
+			}
+		}
+	}
+}
+
+func TestRefundOnPanic(t *testing.T) {
+	tests := []struct {
+		refund bool
+		want   string
+	}{
+		{false, "0"},
+		{true, "1"},
+	}
+
+	for _, tt := range tests {
+		m, err := NewWithConfigE(Config{Store: NewMemoryStore(), Max: 2, RefundOnPanic: tt.refund})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := echo.New()
+		e.Logger.SetOutput(ioutil.Discard)
+		e.Use(middleware.Recover(), m)
+		e.GET("/panic", func(echo.Context) error { panic("boom") })
+		e.GET("/", func(ctx echo.Context) error { return ctx.NoContent(http.StatusOK) })
+
+		if got := serve(e, http.MethodGet, "/panic").Code; got != http.StatusInternalServerError {
+			t.Fatalf("refund %v: panic code = %d, want 500", tt.refund, got)
+		}
+
+		if got := serve(e, http.MethodGet, "/").Header().Get(defaultRemainingHeader); got != tt.want {
+			t.Errorf("refund %v: remaining = %q, want %q", tt.refund, got, tt.want)
+		}
+	}
+}
+
+func TestWarnThreshold(t *testing.T) {
+	e := newServer(t, Config{Store: testutil.AllowThenDeny(10), Max: 10, WarnThreshold: 0.3})
+
+	want := []string{"", "", "", "", "", "", "", "2 of 10 requests remaining", "1 of 10 requests remaining", "0 of 10 requests remaining", ""}
+	for i, warning := range want {
+		if got := serve(e, http.MethodGet, "/").Header().Get(defaultWarnHeader); got != warning {
+			t.Errorf("request %d: warning = %q, want %q", i, got, warning)
+		}
+	}
+}
+
+// recorder is a Logger keeping what it's given
+type recorder struct {
+	errors, warnings []string
+}
+
+func (r *recorder) Error(i ...interface{}) { r.errors = append(r.errors, fmt.Sprint(i...)) }
+func (r *recorder) Warn(i ...interface{})  { r.warnings = append(r.warnings, fmt.Sprint(i...)) }
+
+func TestDryRun(t *testing.T) {
+	var denied int
+	logger := &recorder{}
+
+	e := newServer(t, Config{
+		Store:    NewMemoryStore(),
+		Max:      1,
+		DryRun:   true,
+		Logger:   logger,
+		OnDenied: func(echo.Context, *go_limiter.Result) { denied++ },
+	})
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusOK}
+	if got := codes(e, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+
+	if rec := serve(e, http.MethodGet, "/"); rec.Header().Get(defaultRemainingHeader) != "0" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("headers = %v, want the ones of a denial", rec.Header())
+	}
+
+	if denied != 3 || len(logger.warnings) != 3 {
+		t.Errorf("denied %d times with %d warnings, want 3 and 3", denied, len(logger.warnings))
+	}
+}
+
+func TestGlobalMax(t *testing.T) {
+	e := newServer(t, Config{Store: NewMemoryStore(), Max: 2, GlobalMax: 3, GlobalPeriod: time.Minute})
+
+	tests := []struct {
+		ip        string
+		wantCode  int
+		wantScope string
+	}{
+		{"198.51.100.1", http.StatusOK, ""},
+		{"198.51.100.1", http.StatusOK, ""},
+		{"198.51.100.1", http.StatusTooManyRequests, "key"},
+		{"198.51.100.2", http.StatusOK, ""},
+		{"198.51.100.3", http.StatusTooManyRequests, "global"},
+		{"198.51.100.4", http.StatusTooManyRequests, "global"},
+	}
+
+	for i, tt := range tests {
+		rec := serve(e, http.MethodGet, "/", echo.HeaderXRealIP, tt.ip)
+		if got := rec.Header().Get(defaultScopeHeader); rec.Code != tt.wantCode || got != tt.wantScope {
+			t.Errorf("request %d: code %d scope %q, want %d %q", i, rec.Code, got, tt.wantCode, tt.wantScope)
+		}
+	}
+}
+
+func TestEmitPolicyHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"disabled", Config{Max: 100}, ""},
+		{"single", Config{Max: 100, EmitPolicyHeader: true}, "100;w=60"},
+		{"limits", Config{Limits: []LimitRule{{Max: 10, Period: time.Second}, {Max: 100}}, EmitPolicyHeader: true}, "10;w=1, 100;w=60"},
+		{"headers disabled", Config{Max: 100, EmitPolicyHeader: true, DisableHeaders: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = testutil.AllowAll()
+			tt.config.Period = time.Minute
+
+			if got := serve(newServer(t, tt.config), http.MethodGet, "/").Header().Get("RateLimit-Policy"); got != tt.want {
+				t.Errorf("policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayMode(t *testing.T) {
+	e := newServer(t, Config{
+		Store:      NewMemoryStore(),
+		Max:        1,
+		Period:     time.Minute,
+		Mode:       DelayMode,
+		MaxDelay:   50 * time.Millisecond,
+		Escalation: []EscalationRule{{Violations: 3, Window: time.Minute, Ban: time.Hour}},
+	})
+
+	tests := []struct {
+		name     string
+		wantCode int
+		delayed  bool
+	}{
+		{"allowed", http.StatusOK, false},
+		{"delayed", http.StatusOK, true},
+		{"delayed again", http.StatusOK, true},
+		{"banning violation", http.StatusTooManyRequests, false},
+		{"banned", http.StatusTooManyRequests, false},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		code := serve(e, http.MethodGet, "/").Code
+
+		if delayed := time.Since(start) >= 50*time.Millisecond; code != tt.wantCode || delayed != tt.delayed {
+			t.Errorf("%s: code %d delayed %v, want %d %v", tt.name, code, delayed, tt.wantCode, tt.delayed)
+		}
+	}
+}
+
+// delays is a DelayCollector recording the allowances and the delays
+type delays struct {
+	allowed int
+	waits   []time.Duration
+}
+
+func (d *delays) OnAllowed(echo.Context, *go_limiter.Result) { d.allowed++ }
+func (*delays) OnDenied(echo.Context, *go_limiter.Result)    {}
+func (*delays) OnError(echo.Context, error)                  {}
+func (*delays) ObserveLatency(time.Duration)                 {}
+
+func (d *delays) ObserveDelay(_ echo.Context, wait time.Duration) {
+	d.waits = append(d.waits, wait)
+}
+
+func TestDelayModeHeaders(t *testing.T) {
+	collector := &delays{}
+	var onAllowed int
+
+	m, err := NewWithConfigE(Config{
+		Store:            NewMemoryStore(),
+		Max:              1,
+		Period:           time.Minute,
+		Mode:             DelayMode,
+		MaxDelay:         20 * time.Millisecond,
+		MetricsCollector: collector,
+		OnAllowed:        func(echo.Context, *go_limiter.Result) { onAllowed++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.GET("/", func(ctx echo.Context) error {
+		if _, ok := ctx.Get(DefaultContextKey).(*go_limiter.Result); !ok {
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+
+		return ctx.NoContent(http.StatusOK)
+	})
+
+	serve(e, http.MethodGet, "/")
+	rec := serve(e, http.MethodGet, "/")
+
+	h := rec.Header()
+	if rec.Code != http.StatusOK || h.Get(defaultLimitHeader) != "1" || h.Get(defaultRemainingHeader) != "0" || h.Get("Retry-After") != "" {
+		t.Errorf("code %d headers %v, want 200 with the limit, none remaining and no Retry-After", rec.Code, h)
+	}
+
+	if collector.allowed != 2 || onAllowed != 2 || !reflect.DeepEqual(collector.waits, []time.Duration{20 * time.Millisecond}) {
+		t.Errorf("allowed %d OnAllowed %d waits %v, want 2, 2 and one of 20ms", collector.allowed, onAllowed, collector.waits)
+	}
+}
+
+func TestDelayModeCanceled(t *testing.T) {
+	e := newServer(t, Config{Store: testutil.AllowThenDeny(0), Mode: DelayMode, MaxDelay: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want nothing for a gone client", rec.Body.String())
+	}
+}
+
+func TestSkipMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		method  string
+		want    int
+	}{
+		{"default options", nil, http.MethodOptions, 0},
+		{"default get", nil, http.MethodGet, 1},
+		{"custom", []string{http.MethodHead}, http.MethodHead, 0},
+		{"custom options", []string{http.MethodHead}, http.MethodOptions, 1},
+		{"empty", []string{}, http.MethodOptions, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testutil.AllowAll()
+			rec := serve(newServer(t, Config{Store: store, SkipMethods: tt.methods}), tt.method, "/")
+
+			if got := len(store.Calls()); got != tt.want {
+				t.Errorf("store calls = %d, want %d", got, tt.want)
+			}
+
+			if limited := rec.Header().Get(defaultLimitHeader) != ""; limited != (tt.want == 1) {
+				t.Errorf("limit header set %v, want %v", limited, tt.want == 1)
+			}
+		})
+	}
+}
+
+func TestOnMissingKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     uint
+		wantCode int
+		wantKey  string
+	}{
+		{"ip", MissingKeyIP, http.StatusOK, "echo_limiter:sliding_window:192.0.2.1"},
+		{"deny", MissingKeyDeny, http.StatusTooManyRequests, ""},
+		{"skip", MissingKeySkip, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testutil.AllowAll()
+			e := newServer(t, Config{
+				Store:        store,
+				Key:          func(echo.Context) string { return "" },
+				OnMissingKey: tt.mode,
+			})
+
+			if got := serve(e, http.MethodGet, "/").Code; got != tt.wantCode {
+				t.Errorf("code = %d, want %d", got, tt.wantCode)
+			}
+
+			var key string
+			if calls := store.Calls(); len(calls) > 0 {
+				key = calls[0].Key
+			}
+
+			if key != tt.wantKey {
+				t.Errorf("key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	_, client := newRedis(t)
+
+	tests := []struct {
+		name   string
+		config Config
+		want   int
+	}{
+		{"redis", Config{Rediser: client, Algorithm: GCRAAlgorithm}, 5},
+		{"memory", Config{Store: NewMemoryStore(), Algorithm: GCRAAlgorithm}, 5},
+		{"capped", Config{Store: NewMemoryStore(), Algorithm: GCRAAlgorithm, WarmUp: time.Hour}, 1},
+		{"sliding window", Config{Store: NewMemoryStore(), Algorithm: SlidingWindowAlgorithm}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Max = 10
+			tt.config.Period = 10 * time.Second
+			if tt.config.WarmUp == 0 {
+				tt.config.WarmUp = 5 * time.Second
+			}
+
+			allowed := 0
+			for _, code := range codes(newServer(t, tt.config), 12) {
+				if code == http.StatusOK {
+					allowed++
+				}
+			}
+
+			if allowed != tt.want {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    string
+		wantErr bool
+	}{
+		{"literal", Config{Message: "retry in {{.RetryAfter}}s"}, "retry in {{.RetryAfter}}s", false},
+		{"template", Config{Message: "{{.Remaining}} of {{.Limit}}, retry in {{.RetryAfter}}s", MessageTemplate: true}, "0 of 1, retry in 60s", false},
+		{"blacklist", Config{Message: "retry in {{.RetryAfter}}s", MessageTemplate: true, Blacklist: []string{"192.0.2.1"}}, "retry in 0s", false},
+		{"invalid", Config{Message: "{{.Missing", MessageTemplate: true}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = testutil.AllowThenDeny(0)
+			tt.config.Max = 1
+			tt.config.Period = time.Minute
+
+			m, err := NewWithConfigE(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			e := echo.New()
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error { return nil })
+
+			if got := serve(e, http.MethodGet, "/").Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentRemaining(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		const max = 50
+		e := newServer(t, Config{Rediser: client, Max: max, Burst: max, Period: time.Hour, Algorithm: algorithm})
+
+		remaining := make(chan string, max)
+		var wg sync.WaitGroup
+		for i := 0; i < max; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				remaining <- serve(e, http.MethodGet, "/").Header().Get(defaultRemainingHeader)
+			}()
+		}
+		wg.Wait()
+		close(remaining)
+
+		seen := make(map[string]bool, max)
+		for r := range remaining {
+			if seen[r] {
+				t.Errorf("algorithm %d: remaining %s reported twice", algorithm, r)
+			}
+			seen[r] = true
+		}
+
+		if len(seen) != max {
+			t.Errorf("algorithm %d: %d distinct remaining values, want %d", algorithm, len(seen), max)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	mr, client := newRedis(t)
+	mr.Close()
+
+	t.Run("injected", func(t *testing.T) {
+		logger := &recorder{}
+		serve(newServer(t, Config{Rediser: client, Logger: logger}), http.MethodGet, "/")
+
+		if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "connect") {
+			t.Errorf("errors = %q, want the redis error", logger.errors)
+		}
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		var out bytes.Buffer
+		e := newServer(t, Config{Rediser: client})
+		e.Logger.SetOutput(&out)
+		serve(e, http.MethodGet, "/")
+
+		if !strings.Contains(out.String(), "connect") {
+			t.Errorf("echo log = %q, want the redis error", out.String())
+		}
+	})
+}
+
+func TestFailClosed(t *testing.T) {
+	tests := []struct {
+		name        string
+		skip, close bool
+		wantCode    int
+		wantHandler bool
+	}{
+		{"default", false, false, http.StatusInternalServerError, true},
+		{"fail open", true, false, http.StatusOK, false},
+		{"fail closed", false, true, http.StatusServiceUnavailable, false},
+		{"skip takes precedence", true, true, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled bool
+			e := newServer(t, Config{
+				Store:       failing{},
+				Logger:      discard{},
+				SkipOnError: tt.skip,
+				FailClosed:  tt.close,
+				ErrHandler: func(error, echo.Context) error {
+					handled = true
+					return echo.NewHTTPError(http.StatusInternalServerError)
+				},
+			})
+
+			rec := serve(e, http.MethodGet, "/")
+			if rec.Code != tt.wantCode || handled != tt.wantHandler {
+				t.Errorf("code %d ErrHandler called %v, want %d %v", rec.Code, handled, tt.wantCode, tt.wantHandler)
+			}
+
+			if tt.close && !tt.skip && (rec.Body.String() != defaultErrorMessage || rec.Header().Get("Retry-After") != "1") {
+				t.Errorf("body %q Retry-After %q, want the error message and 1", rec.Body.String(), rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestLimitProviderPeriod(t *testing.T) {
+	rules := map[string]LimitRule{
+		"free":    {Max: 2, Period: time.Minute},
+		"premium": {Max: 2, Period: time.Hour, Algorithm: GCRAAlgorithm},
+	}
+
+	store := testutil.AllowAll()
+	e := newServer(t, Config{
+		Store:        store,
+		ResetAsDelta: true,
+		Key:          func(ctx echo.Context) string { return ctx.Request().Header.Get("Plan") },
+		LimitProvider: providerFunc(func(ctx echo.Context) (LimitRule, error) {
+			return rules[ctx.Request().Header.Get("Plan")], nil
+		}),
+	})
+
+	tests := []struct {
+		plan      string
+		reset     string
+		algorithm uint
+	}{
+		{"free", "60", SlidingWindowAlgorithm},
+		{"premium", "3600", GCRAAlgorithm},
+		{"free", "60", SlidingWindowAlgorithm},
+	}
+
+	for i, tt := range tests {
+		if got := serve(e, http.MethodGet, "/", "Plan", tt.plan).Header().Get(defaultResetHeader); got != tt.reset {
+			t.Errorf("request %d %s: reset = %q, want %q", i, tt.plan, got, tt.reset)
+		}
+
+		if call := store.Calls()[i]; call.Limit.Period != rules[tt.plan].Period || call.Limit.Algorithm != tt.algorithm {
+			t.Errorf("request %d %s: limit = %+v", i, tt.plan, call.Limit)
+		}
+	}
+}
+
+func TestHeaderWriter(t *testing.T) {
+	var rules []LimitRule
+	e := newServer(t, Config{
+		Store:            testutil.AllowThenDeny(1),
+		Max:              1,
+		Period:           time.Minute,
+		EmitPolicyHeader: true,
+		HeaderWriter: func(ctx echo.Context, result *go_limiter.Result, rule LimitRule) {
+			rules = append(rules, rule)
+			ctx.Response().Header().Set("Quota", fmt.Sprintf("%d/%d allowed=%v", result.Remaining, rule.Max, result.Allowed))
+		},
+	})
+
+	tests := []struct {
+		code  int
+		quota string
+	}{
+		{http.StatusOK, "0/1 allowed=true"},
+		{http.StatusTooManyRequests, "0/1 allowed=false"},
+	}
+
+	for i, tt := range tests {
+		rec := serve(e, http.MethodGet, "/")
+		if rec.Code != tt.code || rec.Header().Get("Quota") != tt.quota {
+			t.Errorf("request %d: code %d quota %q, want %d %q", i, rec.Code, rec.Header().Get("Quota"), tt.code, tt.quota)
+		}
+
+		for _, name := range []string{defaultLimitHeader, defaultRemainingHeader, defaultResetHeader, "Retry-After"} {
+			if rec.Header().Get(name) != "" {
+				t.Errorf("request %d: default header %s set", i, name)
+			}
+		}
+
+		if rec.Header().Get("RateLimit-Policy") != "1;w=60" {
+			t.Errorf("request %d: policy header missing", i)
+		}
+	}
+
+	if len(rules) != 2 || rules[0].Period != time.Minute {
+		t.Errorf("rules = %+v, want the rule of both requests", rules)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantHeader string
+	}{
+		{"header", "X-RateLimit-Bypassed", "error"},
+		{"no header", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bypassed []error
+			e := newServer(t, Config{
+				Store:        failing{},
+				Logger:       discard{},
+				SkipOnError:  true,
+				BypassHeader: tt.header,
+				OnBypass:     func(_ echo.Context, err error) { bypassed = append(bypassed, err) },
+			})
+
+			rec := serve(e, http.MethodGet, "/")
+			if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Bypassed") != tt.wantHeader {
+				t.Errorf("code %d bypass header %q, want 200 %q", rec.Code, rec.Header().Get("X-RateLimit-Bypassed"), tt.wantHeader)
+			}
+
+			if len(bypassed) != 1 || bypassed[0].Error() != "down" {
+				t.Errorf("OnBypass got %v, want the store error", bypassed)
+			}
+		})
+	}
+}
+
+func TestHandlerEndsTheChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(echo.Context) error
+		want    int
+	}{
+		{"default", nil, http.StatusTooManyRequests},
+		{"nil return", func(ctx echo.Context) error { return ctx.NoContent(http.StatusTeapot) }, http.StatusTeapot},
+		{"nil without response", func(echo.Context) error { return nil }, http.StatusOK},
+		{"error", func(echo.Context) error { return echo.NewHTTPError(http.StatusForbidden) }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewWithConfigE(Config{Store: testutil.AllowThenDeny(0), Handler: tt.handler})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var reached bool
+			trailing := func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(ctx echo.Context) error {
+					reached = true
+					return next(ctx)
+				}
+			}
+
+			e := echo.New()
+			e.Use(m, trailing)
+			e.GET("/", func(ctx echo.Context) error {
+				reached = true
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			rec := serve(e, http.MethodGet, "/")
+			if rec.Code != tt.want || reached {
+				t.Errorf("code %d chain reached %v, want %d false", rec.Code, reached, tt.want)
+			}
+		})
+	}
+}
+
+// utilizations is an UtilizationCollector recording the utilizations
+type utilizations struct {
+	ratios []float64
+}
+
+func (*utilizations) OnAllowed(echo.Context, *go_limiter.Result) {}
+func (*utilizations) OnDenied(echo.Context, *go_limiter.Result)  {}
+func (*utilizations) OnError(echo.Context, error)                {}
+func (*utilizations) ObserveLatency(time.Duration)               {}
+
+func (u *utilizations) ObserveUtilization(_ echo.Context, ratio float64) {
+	u.ratios = append(u.ratios, ratio)
+}
+
+func TestUtilizationCollector(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []float64
+	}{
+		{"sliding window", Config{Max: 4}, []float64{0.25, 0.5, 0.75, 1, 1}},
+		{"gcra burst", Config{Max: 8, Burst: 4, Algorithm: GCRAAlgorithm}, []float64{0.25, 0.5, 0.75, 1, 1}},
+		{"blacklisted", Config{Max: 4, Blacklist: []string{"192.0.2.1"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &utilizations{}
+			tt.config.Store = NewMemoryStore()
+			tt.config.Period = time.Hour
+			tt.config.MetricsCollector = collector
+
+			codes(newServer(t, tt.config), 5)
+
+			if !reflect.DeepEqual(collector.ratios, tt.want) {
+				t.Errorf("utilizations = %v, want %v", collector.ratios, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludePath(t *testing.T) {
+	store := testutil.AllowAll()
+	m, err := NewWithConfigE(Config{Store: store, IncludePath: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.GET("/users/:id", func(echo.Context) error { return nil })
+	e.GET("/login", func(echo.Context) error { return nil })
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/1", "echo_limiter:sliding_window:/users/:id:192.0.2.1"},
+		{"/users/2", "echo_limiter:sliding_window:/users/:id:192.0.2.1"},
+		{"/login", "echo_limiter:sliding_window:/login:192.0.2.1"},
+		{"/random/1", "echo_limiter:sliding_window:unmatched:192.0.2.1"},
+		{"/random/2", "echo_limiter:sliding_window:unmatched:192.0.2.1"},
+	}
+
+	for i, tt := range tests {
+		serve(e, http.MethodGet, tt.path)
+
+		if got := store.Calls()[i].Key; got != tt.want {
+			t.Errorf("%s: key = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDisableRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+		want    []bool
+	}{
+		{"default", false, []bool{false, true}},
+		{"disabled", true, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(t, Config{Store: testutil.AllowThenDeny(1), DisableRetryAfter: tt.disable})
+
+			for i, want := range tt.want {
+				rec := serve(e, http.MethodGet, "/")
+				if got := rec.Header().Get("Retry-After") != ""; got != want {
+					t.Errorf("request %d, code %d: Retry-After set %v, want %v", i, rec.Code, got, want)
+				}
+
+				if rec.Header().Get(defaultRemainingHeader) == "" {
+					t.Errorf("request %d: remaining header missing", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusCodeFunc(t *testing.T) {
+	e := newServer(t, Config{
+		Store:      testutil.AllowThenDeny(0),
+		StatusCode: http.StatusTooManyRequests,
+		StatusCodeFunc: func(ctx echo.Context) int {
+			switch ctx.Request().UserAgent() {
+			case "bot":
+				return http.StatusForbidden
+			case "batch":
+				return http.StatusServiceUnavailable
+			case "broken":
+				return http.StatusOK
+			}
+
+			return 0
+		},
+	})
+
+	tests := []struct {
+		agent string
+		want  int
+	}{
+		{"bot", http.StatusForbidden},
+		{"batch", http.StatusServiceUnavailable},
+		{"browser", http.StatusTooManyRequests},
+		{"broken", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		if got := serve(e, http.MethodGet, "/", "User-Agent", tt.agent).Code; got != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.agent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package echo_limiter
+
+import (
+	"path"
+	"strings"
+)
+
+// matcher decides from Config.Include and Config.Exclude whether a path is
+// limited
+type matcher struct {
+	include []string
+	exclude []string
+}
+
+// limited reports whether requests to p are limited
+func (m matcher) limited(p string) bool {
+	if matchAny(m.exclude, p) {
+		return false
+	}
+
+	return len(m.include) == 0 || matchAny(m.include, p)
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if match(pattern, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether p matches pattern. A plain pattern or one ending
+// in "/*" is a prefix on segment boundaries, "/api" and "/api/*" match
+// /api and everything under it but not /apiary, one ending in another "*"
+// is a plain prefix, e.g. "/api*" matches /apiary too, any other is a
+// path.Match glob, e.g. "/users/*/avatar"
+func match(pattern, p string) bool {
+	prefix := strings.TrimSuffix(pattern, "*")
+	if !strings.ContainsAny(prefix, `*?[\`) {
+		if prefix != pattern && !strings.HasSuffix(prefix, "/") {
+			return strings.HasPrefix(p, prefix)
+		}
+
+		prefix = strings.TrimSuffix(prefix, "/")
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
@@ -0,0 +1,64 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shareed2k/echo_limiter/testutil"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/api", "/api", true},
+		{"/api", "/api/users", true},
+		{"/api", "/apiary", false},
+		{"/api/", "/api/users", true},
+		{"/api/*", "/api", true},
+		{"/api/*", "/api/users/1", true},
+		{"/api/*", "/apiary", false},
+		{"/api*", "/apiary", true},
+		{"/api*", "/ap", false},
+		{"/", "/anything", true},
+		{"/users/*/avatar", "/users/1/avatar", true},
+		{"/users/*/avatar", "/users/1/2/avatar", false},
+		{"/users/[0-9]", "/users/7", true},
+		{"/users/[0-9]", "/users/a", false},
+		{"/users/[", "/users/[", false},
+	}
+
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	store := testutil.AllowThenDeny(0)
+	e := newServer(t, Config{
+		Store:   store,
+		Include: []string{"/api"},
+		Exclude: []string{"/api/health"},
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api", http.StatusTooManyRequests},
+		{"/api/users", http.StatusTooManyRequests},
+		{"/apiary", http.StatusOK},
+		{"/api/health", http.StatusOK},
+		{"/", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := serve(e, http.MethodGet, tt.path).Code; got != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
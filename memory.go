@@ -0,0 +1,164 @@
+package echo_limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+// memoryStore is an in-process gcra limiter. It's per instance and not
+// distributed, so with N instances a key may get up to N times its limit
+type memoryStore struct {
+	mu    sync.Mutex
+	tats  map[string]time.Time
+	sweep time.Time
+}
+
+// NewMemoryStore returns a Store keeping the limits in process memory,
+// sliding window limits are approximated with gcra
+func NewMemoryStore() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		tats: make(map[string]time.Time),
+	}
+}
+
+// AllowN implements Store, the burst of sliding window limits is their rate
+func (m *memoryStore) AllowN(ctx context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error) {
+	emissionInterval, burstOffset := memoryLimit(limit)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+
+	tat, ok := m.tats[key]
+	if !ok {
+		tat = fresh(ctx, now, limit, emissionInterval, burstOffset)
+	} else if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(emissionInterval * time.Duration(n))
+	if newTat.Before(now) {
+		// Refunded past the initial state
+		newTat = now
+	}
+	diff := now.Sub(newTat.Add(-burstOffset))
+	remaining := int64(float64(diff)/float64(emissionInterval) + 0.5)
+
+	result := &go_limiter.Result{
+		Limit: limit,
+		Key:   key,
+	}
+
+	if diff < -emissionInterval/2 {
+		result.RetryAfter = -diff
+		result.ResetAfter = tat.Sub(now)
+
+		return result, nil
+	}
+
+	m.tats[key] = newTat
+
+	result.Allowed = true
+	result.Remaining = remaining
+	result.RetryAfter = -1
+	result.ResetAfter = newTat.Sub(now)
+
+	return result, nil
+}
+
+// Peek implements Store
+func (m *memoryStore) Peek(ctx context.Context, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	emissionInterval, burstOffset := memoryLimit(limit)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	tat, ok := m.tats[key]
+	if !ok {
+		tat = fresh(ctx, now, limit, emissionInterval, burstOffset)
+	} else if tat.Before(now) {
+		tat = now
+	}
+
+	diff := now.Sub(tat.Add(-burstOffset))
+
+	result := &go_limiter.Result{
+		Limit:      limit,
+		Key:        key,
+		Remaining:  int64(float64(diff)/float64(emissionInterval) + 0.5),
+		RetryAfter: -1,
+		ResetAfter: tat.Sub(now),
+	}
+
+	if result.Remaining < 1 {
+		result.Remaining = 0
+		result.RetryAfter = emissionInterval - diff
+	}
+
+	result.Allowed = result.Remaining > 0
+
+	return result, nil
+}
+
+// Reset implements Store
+func (m *memoryStore) Reset(_ context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.tats, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// memoryLimit returns the gcra parameters of limit
+func memoryLimit(limit *go_limiter.Limit) (emissionInterval, burstOffset time.Duration) {
+	burst := limit.Burst
+	if burst < 1 || limit.Algorithm != go_limiter.GCRAAlgorithm {
+		burst = limit.Rate
+	}
+
+	emissionInterval = limit.Period / time.Duration(limit.Rate)
+
+	return emissionInterval, emissionInterval * time.Duration(burst)
+}
+
+// fresh returns the theoretical arrival time of a new key, later than now
+// with a warm-up of gcra limits, keeping at least one token
+func fresh(ctx context.Context, now time.Time, limit *go_limiter.Limit, emissionInterval, burstOffset time.Duration) time.Time {
+	d := warmUp(ctx)
+	if d <= 0 || limit.Algorithm != go_limiter.GCRAAlgorithm {
+		return now
+	}
+
+	if max := burstOffset - emissionInterval; d > max {
+		d = max
+	}
+
+	return now.Add(d)
+}
+
+// expire drops the keys that returned to their initial state, at most
+// once a minute
+func (m *memoryStore) expire(now time.Time) {
+	if now.Before(m.sweep) {
+		return
+	}
+
+	for key, tat := range m.tats {
+		if tat.Before(now) {
+			delete(m.tats, key)
+		}
+	}
+
+	m.sweep = now.Add(time.Minute)
+}
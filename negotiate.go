@@ -0,0 +1,141 @@
+package echo_limiter
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NegotiatedOptions configures NegotiatedHandler and NegotiatedErrHandler
+type NegotiatedOptions struct {
+	// StatusCode of the response
+	// Default: 429, 503 for NegotiatedErrHandler
+	StatusCode int
+
+	// Message is the text of every representation
+	// Default: "Too many requests, please try again later.", for
+	// NegotiatedErrHandler "Service temporarily unavailable, please try
+	// again later."
+	Message string
+
+	// HTML is the page sent to browsers
+	// Default: a minimal page showing Message
+	HTML string
+}
+
+// NegotiatedHandler returns a Config.Handler answering in the
+// representation the Accept header prefers, HTML for browsers, JSON like
+// MessageJSON for API clients and plain text otherwise, e.g. for curl
+func NegotiatedHandler(opts NegotiatedOptions) func(echo.Context) error {
+	if opts.StatusCode == 0 {
+		opts.StatusCode = defaultStatusCode
+	}
+
+	if opts.Message == "" {
+		opts.Message = defaultMessage
+	}
+
+	return negotiated(opts)
+}
+
+// NegotiatedErrHandler returns a Config.ErrHandler answering like
+// NegotiatedHandler, with a Retry-After of a second like the default
+// ErrHandler. The error itself is only logged by the limiter
+func NegotiatedErrHandler(opts NegotiatedOptions) func(error, echo.Context) error {
+	if opts.StatusCode == 0 {
+		opts.StatusCode = defaultErrorStatusCode
+	}
+
+	if opts.Message == "" {
+		opts.Message = defaultErrorMessage
+	}
+
+	respond := negotiated(opts)
+
+	return func(_ error, ctx echo.Context) error {
+		// The store is likely to be back soon, so it is temporary
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(defaultErrorRetryAfter))
+
+		return respond(ctx)
+	}
+}
+
+// negotiated returns a handler writing the representation of opts the
+// Accept header prefers
+func negotiated(opts NegotiatedOptions) func(echo.Context) error {
+	if opts.HTML == "" {
+		opts.HTML = "<!DOCTYPE html>\n<html><head><title>" + http.StatusText(opts.StatusCode) +
+			"</title></head><body><p>" + html.EscapeString(opts.Message) + "</p></body></html>\n"
+	}
+
+	return func(ctx echo.Context) error {
+		switch negotiate(ctx.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML, echo.MIMEApplicationJSON, echo.MIMETextPlain) {
+		case echo.MIMETextHTML:
+			return ctx.HTML(opts.StatusCode, opts.HTML)
+		case echo.MIMEApplicationJSON:
+			body := map[string]interface{}{
+				"message": opts.Message,
+			}
+
+			// The limiter sets Retry-After before calling the Handler
+			if retryAfter, err := strconv.ParseInt(ctx.Response().Header().Get("Retry-After"), 10, 64); err == nil {
+				body["retry_after"] = retryAfter
+			}
+
+			return ctx.JSON(opts.StatusCode, body)
+		default:
+			return ctx.String(opts.StatusCode, opts.Message)
+		}
+	}
+}
+
+// negotiate returns the first of offers accept prefers, the last offer
+// when accept is empty or matches none
+func negotiate(accept string, offers ...string) string {
+	type mediaRange struct {
+		mime string
+		q    float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		r := mediaRange{mime: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, param := range params[1:] {
+			if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+				if q, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+
+		if r.mime != "" && r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+
+	// Most preferred first, the order of accept breaks ties
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		if r.mime == "*/*" {
+			// Anything goes, e.g. curl, so the last offer
+			break
+		}
+
+		for _, offer := range offers {
+			if r.mime == offer || (strings.HasSuffix(r.mime, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.mime, "*"))) {
+				return offer
+			}
+		}
+	}
+
+	return offers[len(offers)-1]
+}
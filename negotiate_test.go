@@ -0,0 +1,81 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/echo_limiter/testutil"
+)
+
+func TestNegotiatedHandler(t *testing.T) {
+	e := newServer(t, Config{
+		Store:   testutil.AllowThenDeny(0),
+		Handler: NegotiatedHandler(NegotiatedOptions{Message: "slow <down>"}),
+	})
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"text/html,application/xhtml+xml,*/*;q=0.8", echo.MIMETextHTML, "<p>slow &lt;down&gt;</p>"},
+		{"application/json", echo.MIMEApplicationJSON, `"message":"slow \u003cdown\u003e","retry_after":60`},
+		{"text/html;q=0.5, application/json", echo.MIMEApplicationJSON, `"retry_after":60`},
+		{"text/*", echo.MIMETextHTML, "<p>slow"},
+		{"*/*", echo.MIMETextPlain, "slow <down>"},
+		{"", echo.MIMETextPlain, "slow <down>"},
+		{"image/png", echo.MIMETextPlain, "slow <down>"},
+	}
+
+	for _, tt := range tests {
+		rec := serve(e, http.MethodGet, "/", echo.HeaderAccept, tt.accept)
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("accept %q: code = %d, want 429", tt.accept, rec.Code)
+		}
+
+		if got := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("accept %q: content type = %q, want %q", tt.accept, got, tt.contentType)
+		}
+
+		if got := rec.Body.String(); !strings.Contains(got, tt.body) {
+			t.Errorf("accept %q: body = %q, want it to contain %q", tt.accept, got, tt.body)
+		}
+	}
+}
+
+func TestNegotiatedErrHandler(t *testing.T) {
+	e := newServer(t, Config{
+		Store:      failing{},
+		Logger:     discard{},
+		ErrHandler: NegotiatedErrHandler(NegotiatedOptions{}),
+	})
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"text/html", echo.MIMETextHTML, "<title>Service Unavailable</title>"},
+		{"application/json", echo.MIMEApplicationJSON, `"message":"Service temporarily unavailable, please try again later.","retry_after":1`},
+		{"", echo.MIMETextPlain, defaultErrorMessage},
+	}
+
+	for _, tt := range tests {
+		rec := serve(e, http.MethodGet, "/", echo.HeaderAccept, tt.accept)
+
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("accept %q: code %d Retry-After %q, want 503 and 1", tt.accept, rec.Code, rec.Header().Get("Retry-After"))
+		}
+
+		if got := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("accept %q: content type = %q, want %q", tt.accept, got, tt.contentType)
+		}
+
+		if got := rec.Body.String(); !strings.Contains(got, tt.body) {
+			t.Errorf("accept %q: body = %q, want it to contain %q", tt.accept, got, tt.body)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package nethttp runs an echo_limiter.Config as plain net/http
+// middleware, so echo and net/http services can share a limit setup
+//
+// Usage:
+//
+//	m, err := nethttp.New(limiter.Config{
+//	  Rediser: client,
+//	  Max:     100,
+//	}, func(r *http.Request) string {
+//	  return r.Header.Get("X-API-Key")
+//	})
+//	if err != nil {
+//	  log.Fatal(err)
+//	}
+//
+//	http.ListenAndServe(":3000", m(mux))
+//
+// The requests go through the echo middleware on an internal echo
+// instance, the callbacks of Config still get an echo.Context wrapping
+// the request. There is no echo router, so IncludePath puts every
+// request in one bucket and limiter errors are rendered by echo's default
+// error handler
+package nethttp
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	limiter "github.com/shareed2k/echo_limiter"
+)
+
+// New returns a net/http middleware limiting by config, key replaces
+// Config.Key when not nil
+func New(config limiter.Config, key func(*http.Request) string) (func(http.Handler) http.Handler, error) {
+	if key != nil {
+		config.Key = func(ctx echo.Context) string {
+			return key(ctx.Request())
+		}
+	}
+
+	m, err := limiter.NewWithConfigE(config)
+	if err != nil {
+		return nil, err
+	}
+
+	e := echo.New()
+
+	return func(next http.Handler) http.Handler {
+		h := m(func(ctx echo.Context) error {
+			// The response tracks the status for SkipFunc and RefundOn, the
+			// request may carry a body put back by the limiter
+			next.ServeHTTP(ctx.Response(), ctx.Request())
+			return nil
+		})
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := e.NewContext(r, w)
+			if err := h(ctx); err != nil {
+				e.HTTPErrorHandler(err, ctx)
+			}
+		})
+	}, nil
+}
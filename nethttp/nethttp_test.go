@@ -0,0 +1,78 @@
+package nethttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	limiter "github.com/shareed2k/echo_limiter"
+	"github.com/shareed2k/go_limiter"
+)
+
+// failing is a Store whose calls all fail
+type failing struct{ limiter.Store }
+
+func (failing) AllowN(context.Context, string, *go_limiter.Limit, int) (*go_limiter.Result, error) {
+	return nil, errors.New("down")
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(limiter.Config{Store: limiter.NewMemoryStore(), Max: 1}, func(r *http.Request) string {
+		return r.Header.Get("X-API-Key")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	tests := []struct {
+		key       string
+		want      int
+		remaining string
+	}{
+		{"a", http.StatusAccepted, "0"},
+		{"a", http.StatusTooManyRequests, "0"},
+		{"b", http.StatusAccepted, "0"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", tt.key)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want || rec.Header().Get("X-RateLimit-Remaining") != tt.remaining {
+			t.Errorf("request %d: code %d remaining %q, want %d %q", i, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), tt.want, tt.remaining)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(limiter.Config{Store: limiter.NewMemoryStore(), Max: -1}, nil); err == nil {
+		t.Error("no error for an invalid config")
+	}
+
+	m, err := New(limiter.Config{Store: failing{}, Logger: discard{}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	m(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("code = %d, want 503 from the echo error handler", rec.Code)
+	}
+}
+
+// discard is a Logger dropping everything
+type discard struct{}
+
+func (discard) Error(...interface{}) {}
+func (discard) Warn(...interface{})  {}
@@ -0,0 +1,53 @@
+package echo_limiter
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Option sets a field of the Config it's applied to
+type Option func(*Config)
+
+// WithMax sets Config.Max
+func WithMax(max int) Option {
+	return func(config *Config) {
+		config.Max = max
+	}
+}
+
+// WithBurst sets Config.Burst
+func WithBurst(burst int) Option {
+	return func(config *Config) {
+		config.Burst = burst
+	}
+}
+
+// WithPeriod sets Config.Period
+func WithPeriod(period time.Duration) Option {
+	return func(config *Config) {
+		config.Period = period
+	}
+}
+
+// WithAlgorithm sets Config.Algorithm
+func WithAlgorithm(algorithm uint) Option {
+	return func(config *Config) {
+		config.Algorithm = algorithm
+	}
+}
+
+// WithKey sets Config.Key
+func WithKey(key func(echo.Context) string) Option {
+	return func(config *Config) {
+		config.Key = key
+	}
+}
+
+// WithShutdown closes the redis client the limiter created itself, see
+// NewFromURL, when e is shut down
+func WithShutdown(e *echo.Echo) Option {
+	return func(config *Config) {
+		config.shutdown = e
+	}
+}
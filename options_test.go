@@ -0,0 +1,56 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestOptions(t *testing.T) {
+	key := func(echo.Context) string { return "k" }
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		check func(Config) bool
+	}{
+		{"defaults", nil, func(c Config) bool {
+			return c.Max == DefaultConfig.Max && c.Period == DefaultConfig.Period && c.Burst == 0
+		}},
+		{"max", []Option{WithMax(5)}, func(c Config) bool { return c.Max == 5 }},
+		{"later wins", []Option{WithMax(5), WithMax(7)}, func(c Config) bool { return c.Max == 7 }},
+		{"compose", []Option{WithMax(5), WithBurst(2), WithPeriod(time.Hour), WithAlgorithm(GCRAAlgorithm)}, func(c Config) bool {
+			return c.Max == 5 && c.Burst == 2 && c.Period == time.Hour && c.Algorithm == GCRAAlgorithm
+		}},
+		{"key", []Option{WithKey(key)}, func(c Config) bool { return c.Key(nil) == "k" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if config := configWith(tt.opts); !tt.check(config) {
+				t.Errorf("config = %+v", config)
+			}
+		})
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	_, client := newRedis(t)
+
+	m, err := NewE(client, WithMax(1), WithAlgorithm(GCRAAlgorithm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(m)
+	e.GET("/", func(ctx echo.Context) error { return nil })
+
+	want := []int{http.StatusOK, http.StatusTooManyRequests}
+	if got := codes(e, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
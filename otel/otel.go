@@ -0,0 +1,88 @@
+// Package otel provides a echo_limiter.Tracer wrapping the limiter's redis
+// calls in an OpenTelemetry span "echo_limiter.allow", nested in the span
+// of the request context:
+//
+//	echo_limiter.key        the limiter key, hashed with HashKeys
+//	echo_limiter.algorithm  "sliding_window" or "gcra"
+//	echo_limiter.allowed    whether the request was allowed
+//	echo_limiter.remaining  the tokens left
+//
+// Usage, with this package imported as limiterotel:
+//
+//	e.Use(limiter.NewWithConfig(limiter.Config{
+//	  Rediser: client,
+//	  Tracer:  limiterotel.NewTracer(otel.Tracer("echo_limiter"), limiterotel.HashKeys()),
+//	}))
+package otel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/shareed2k/go_limiter"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const spanName = "echo_limiter.allow"
+
+type (
+	// Tracer implements echo_limiter.Tracer
+	Tracer struct {
+		tracer   trace.Tracer
+		hashKeys bool
+	}
+
+	// Option configures a Tracer
+	Option func(*Tracer)
+)
+
+// HashKeys records the SHA-256 of the keys instead of the keys, e.g. when
+// they are API keys
+func HashKeys() Option {
+	return func(t *Tracer) {
+		t.hashKeys = true
+	}
+}
+
+// NewTracer returns a Tracer starting the spans with tracer
+func NewTracer(tracer trace.Tracer, opts ...Option) *Tracer {
+	t := &Tracer{tracer: tracer}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Start implements echo_limiter.Tracer
+func (t *Tracer) Start(ctx context.Context, key string, algorithm uint) (context.Context, func(*go_limiter.Result, error)) {
+	if t.hashKeys {
+		sum := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(sum[:16])
+	}
+
+	name, _ := go_limiter.GetAlgorithmName(algorithm)
+
+	ctx, span := t.tracer.Start(ctx, spanName, trace.WithAttributes(
+		label.String("echo_limiter.key", key),
+		label.String("echo_limiter.algorithm", name),
+	))
+
+	return ctx, func(result *go_limiter.Result, err error) {
+		defer span.End()
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+
+		span.SetAttributes(
+			label.Bool("echo_limiter.allowed", result.Allowed),
+			label.Int64("echo_limiter.remaining", result.Remaining),
+		)
+	}
+}
@@ -0,0 +1,101 @@
+package otel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	limiter "github.com/shareed2k/echo_limiter"
+	"github.com/shareed2k/go_limiter"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+// failing is a Store whose calls all fail
+type failing struct{ limiter.Store }
+
+func (failing) AllowN(context.Context, string, *go_limiter.Limit, int) (*go_limiter.Result, error) {
+	return nil, errors.New("down")
+}
+
+func TestTracer(t *testing.T) {
+	sum := sha256.Sum256([]byte("192.0.2.1"))
+
+	tests := []struct {
+		name       string
+		store      limiter.Store
+		opts       []Option
+		wantKey    string
+		wantStatus codes.Code
+	}{
+		{"allowed", limiter.NewMemoryStore(), nil, "192.0.2.1", codes.Unset},
+		{"hashed", limiter.NewMemoryStore(), []Option{HashKeys()}, hex.EncodeToString(sum[:16]), codes.Unset},
+		{"error", failing{}, nil, "192.0.2.1", codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &oteltest.StandardSpanRecorder{}
+			tracer := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(recorder)).Tracer("test")
+
+			m, err := limiter.NewWithConfigE(limiter.Config{
+				Store:  tt.store,
+				Max:    3,
+				Tracer: NewTracer(tracer, tt.opts...),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := echo.New()
+			e.Logger.SetOutput(ioutil.Discard)
+			e.Use(m)
+			e.GET("/", func(ctx echo.Context) error { return nil })
+
+			ctx, parent := tracer.Start(context.Background(), "request")
+			e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			parent.End()
+
+			spans := recorder.Completed()
+			if len(spans) != 2 || spans[0].Name() != spanName {
+				t.Fatalf("spans = %v, want %s and its parent", spans, spanName)
+			}
+
+			span := spans[0]
+			if span.ParentSpanID() != parent.SpanContext().SpanID {
+				t.Error("span isn't nested in the request span")
+			}
+
+			if span.StatusCode() != tt.wantStatus {
+				t.Errorf("status = %v, want %v", span.StatusCode(), tt.wantStatus)
+			}
+
+			want := map[label.Key]label.Value{
+				"echo_limiter.key":       label.StringValue(tt.wantKey),
+				"echo_limiter.algorithm": label.StringValue("sliding_window"),
+			}
+			if tt.wantStatus != codes.Error {
+				want["echo_limiter.allowed"] = label.BoolValue(true)
+				want["echo_limiter.remaining"] = label.Int64Value(2)
+			}
+
+			attributes := span.Attributes()
+			if len(attributes) != len(want) {
+				t.Errorf("attributes = %v, want %v", attributes, want)
+			}
+
+			for k, v := range want {
+				if got := attributes[k]; got != v {
+					t.Errorf("%s = %v, want %v", k, got.Emit(), v.Emit())
+				}
+			}
+		})
+	}
+}
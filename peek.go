@@ -0,0 +1,126 @@
+package echo_limiter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/shareed2k/go_limiter"
+)
+
+var (
+	// gcraPeekScript reads the theoretical arrival time of a gcra bucket,
+	// same math as go_limiter's gcra script, without updating it
+	gcraPeekScript = newScript(`
+local rate_limit_key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local warm_up = tonumber(ARGV[4])
+
+local emission_interval = period / rate
+local burst_offset = emission_interval * burst
+
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+
+local tat = redis.call("GET", rate_limit_key)
+
+if not tat then
+  tat = now + math.min(warm_up, burst_offset - emission_interval)
+else
+  tat = math.max(tonumber(tat), now)
+end
+
+local diff = now - (tat - burst_offset)
+local remaining = math.floor(diff / emission_interval + 0.5)
+local retry_after = -1
+
+if remaining < 1 then
+  remaining = 0
+  retry_after = emission_interval - diff
+end
+
+return {remaining, tostring(retry_after), tostring(tat - now)}
+`)
+
+	// slidingWindowPeekScript counts the hits of a sliding window bucket
+	// that are still inside the period, without removing the stale ones
+	slidingWindowPeekScript = newScript(`
+local rate_limit_key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+
+local clear_before = "(" .. string.format("%.6f", now - period)
+local count = redis.call("ZCOUNT", rate_limit_key, clear_before, "+inf")
+local retry_after = -1
+
+if count >= rate then
+  local oldest = redis.call("ZRANGEBYSCORE", rate_limit_key, clear_before, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+  retry_after = tonumber(oldest[2]) + period - now
+end
+
+return {math.max(rate - count, 0), tostring(retry_after), tostring(period)}
+`)
+)
+
+// Peek returns the current state of key for limit without consuming a
+// token, e.g. to render a quota status page. prefix is the Config.Prefix
+// of the middleware the key belongs to, preceded by "<Namespace>:" when a
+// Namespace is set. Allowed reports whether the next request would be
+// allowed
+func Peek(rediser redis.UniversalClient, prefix, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	return NewRedisStore(NewScripter(rediser)).Peek(context.Background(), storeKey(prefix, defaultKeySeparator, limit.Algorithm, key), limit)
+}
+
+// peek reads the state of the redis key for limit
+func peek(ctx context.Context, scripter Scripter, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	var (
+		script *script
+		values []interface{}
+	)
+
+	switch limit.Algorithm {
+	case go_limiter.GCRAAlgorithm:
+		script = gcraPeekScript
+		values = []interface{}{limit.Burst, limit.Rate, limit.Period.Seconds(), warmUp(ctx).Seconds()}
+	case go_limiter.SlidingWindowAlgorithm:
+		script = slidingWindowPeekScript
+		values = []interface{}{limit.Rate, limit.Period.Seconds()}
+	default:
+		return nil, errAlgorithmNotSupported
+	}
+
+	v, err := script.run(ctx, scripter, []string{key}, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	values = v.([]interface{})
+
+	retryAfter, err := strconv.ParseFloat(values[1].(string), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	resetAfter, err := strconv.ParseFloat(values[2].(string), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := values[0].(int64)
+
+	return &go_limiter.Result{
+		Limit:      limit,
+		Key:        key,
+		Allowed:    remaining > 0,
+		Remaining:  remaining,
+		RetryAfter: dur(retryAfter),
+		ResetAfter: dur(resetAfter),
+	}, nil
+}
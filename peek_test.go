@@ -0,0 +1,29 @@
+package echo_limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+func TestPeek(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{Rediser: client, Max: 5, Burst: 5, Period: time.Minute, Algorithm: algorithm})
+		codes(e, 2)
+
+		limit := &go_limiter.Limit{Rate: 5, Burst: 5, Period: time.Minute, Algorithm: algorithm}
+		for i := 0; i < 2; i++ {
+			r, err := Peek(client, DefaultKeyPrefix, "192.0.2.1", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !r.Allowed || r.Remaining != 3 {
+				t.Errorf("algorithm %d peek %d: allowed %v remaining %d, want true 3", algorithm, i, r.Allowed, r.Remaining)
+			}
+		}
+	}
+}
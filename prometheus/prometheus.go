@@ -0,0 +1,97 @@
+// Package prometheus provides a echo_limiter.MetricsCollector that exports
+// the limiter outcomes as prometheus metrics:
+//
+//	echo_limiter_requests_total{result="allowed|denied|error"}
+//	echo_limiter_allow_duration_seconds
+//	echo_limiter_utilization_ratio
+//
+// Usage:
+//
+//	collector := prometheus.NewCollector()
+//	prom.MustRegister(collector)
+//
+//	e.Use(limiter.NewWithConfig(limiter.Config{
+//	  Rediser:          client,
+//	  MetricsCollector: collector,
+//	}))
+package prometheus
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shareed2k/go_limiter"
+)
+
+const namespace = "echo_limiter"
+
+// Collector implements echo_limiter.UtilizationCollector and
+// prometheus.Collector
+type Collector struct {
+	requests    *prometheus.CounterVec
+	duration    prometheus.Histogram
+	utilization prometheus.Histogram
+}
+
+// NewCollector returns a new Collector, it still has to be registered
+func NewCollector() *Collector {
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of requests seen by the limiter, by result.",
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "allow_duration_seconds",
+			Help:      "Latency of the limiter calls to redis.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		utilization: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "utilization_ratio",
+			Help:      "Share of their limit used by the limited requests.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}),
+	}
+}
+
+// OnAllowed implements echo_limiter.MetricsCollector
+func (c *Collector) OnAllowed(echo.Context, *go_limiter.Result) {
+	c.requests.WithLabelValues("allowed").Inc()
+}
+
+// OnDenied implements echo_limiter.MetricsCollector
+func (c *Collector) OnDenied(echo.Context, *go_limiter.Result) {
+	c.requests.WithLabelValues("denied").Inc()
+}
+
+// OnError implements echo_limiter.MetricsCollector
+func (c *Collector) OnError(echo.Context, error) {
+	c.requests.WithLabelValues("error").Inc()
+}
+
+// ObserveLatency implements echo_limiter.MetricsCollector
+func (c *Collector) ObserveLatency(d time.Duration) {
+	c.duration.Observe(d.Seconds())
+}
+
+// ObserveUtilization implements echo_limiter.UtilizationCollector
+func (c *Collector) ObserveUtilization(_ echo.Context, ratio float64) {
+	c.utilization.Observe(ratio)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.duration.Describe(ch)
+	c.utilization.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.duration.Collect(ch)
+	c.utilization.Collect(ch)
+}
@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	limiter "github.com/shareed2k/echo_limiter"
+	"github.com/shareed2k/go_limiter"
+)
+
+// failing is a Store whose calls all fail
+type failing struct{ limiter.Store }
+
+func (failing) AllowN(context.Context, string, *go_limiter.Limit, int) (*go_limiter.Result, error) {
+	return nil, errors.New("down")
+}
+
+func serve(t *testing.T, config limiter.Config, n int) {
+	t.Helper()
+
+	m, err := limiter.NewWithConfigE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Logger.SetOutput(ioutil.Discard)
+	e.Use(m)
+	e.GET("/", func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+
+	for i := 0; i < n; i++ {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+
+	serve(t, limiter.Config{Store: limiter.NewMemoryStore(), Max: 1, Burst: 1, MetricsCollector: c}, 3)
+	serve(t, limiter.Config{Store: failing{}, MetricsCollector: c}, 1)
+
+	tests := []struct {
+		result string
+		want   float64
+	}{
+		{"allowed", 1},
+		{"denied", 2},
+		{"error", 1},
+	}
+
+	for _, tt := range tests {
+		if got := promtestutil.ToFloat64(c.requests.WithLabelValues(tt.result)); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.result, got, tt.want)
+		}
+	}
+
+	if n := promtestutil.CollectAndCount(c); n != 5 {
+		t.Errorf("collected %d metrics, want the 3 results, latency and utilization", n)
+	}
+}
@@ -0,0 +1,43 @@
+package echo_limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle lets every message through once per period, see
+// Config.QuietErrors
+type throttle struct {
+	mu     sync.Mutex
+	period time.Duration
+	last   map[string]time.Time
+}
+
+func newThrottle(period time.Duration) *throttle {
+	return &throttle{
+		period: period,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// allow reports whether msg wasn't let through in the period before now
+func (t *throttle) allow(msg string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[msg]; ok && now.Sub(last) < t.period {
+		return false
+	}
+
+	// Errors may carry addresses or keys, drop the stale ones so the map
+	// stays bounded by the messages of one period
+	for m, last := range t.last {
+		if now.Sub(last) >= t.period {
+			delete(t.last, m)
+		}
+	}
+
+	t.last[msg] = now
+
+	return true
+}
@@ -0,0 +1,58 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	start := time.Unix(1000, 0)
+	throttle := newThrottle(time.Minute)
+
+	tests := []struct {
+		msg   string
+		after time.Duration
+		want  bool
+	}{
+		{"down", 0, true},
+		{"down", time.Second, false},
+		{"timeout", time.Second, true},
+		{"down", 59 * time.Second, false},
+		{"down", time.Minute, true},
+		{"timeout", time.Minute, false},
+		{"timeout", time.Minute + time.Second, true},
+	}
+
+	for i, tt := range tests {
+		if got := throttle.allow(tt.msg, start.Add(tt.after)); got != tt.want {
+			t.Errorf("step %d: allow(%q) after %v = %v, want %v", i, tt.msg, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestQuietErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet bool
+		want  int
+	}{
+		{"loud", false, 10},
+		{"quiet", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recorder{}
+			e := newServer(t, Config{Store: failing{}, Logger: logger, QuietErrors: tt.quiet})
+
+			for i := 0; i < 10; i++ {
+				serve(e, http.MethodGet, "/")
+			}
+
+			if len(logger.errors) != tt.want {
+				t.Errorf("logged %d errors, want %d", len(logger.errors), tt.want)
+			}
+		})
+	}
+}
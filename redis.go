@@ -0,0 +1,203 @@
+package echo_limiter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+var (
+	errAlgorithmNotSupported = errors.New("algorithm is not supported")
+
+	// gcraScript is go_limiter's gcra script, kept here because go_limiter
+	// doesn't expose a way to consume more than one token per call
+	// https://github.com/rwz/redis-gcra/blob/master/vendor/perform_gcra_ratelimit.lua
+	gcraScript = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period = ARGV[3]
+local cost = ARGV[4]
+local warm_up = tonumber(ARGV[5])
+
+local emission_interval = period / rate
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+
+local tat = redis.call("GET", rate_limit_key)
+
+if not tat then
+  -- a fresh key starts with part of its burst spent, keeping one token
+  tat = now + math.min(warm_up, burst_offset - emission_interval)
+else
+  tat = tonumber(tat)
+end
+
+-- a refund (negative cost) can't push the bucket past its initial state
+local new_tat = math.max(math.max(tat, now) + increment, now)
+
+local allow_at = new_tat - burst_offset
+local diff = now - allow_at
+
+local limited
+local retry_after
+local reset_after
+
+local remaining = math.floor(diff / emission_interval + 0.5) -- poor man's round
+
+if remaining < 0 then
+  limited = 1
+  remaining = 0
+  reset_after = tat - now
+  retry_after = diff * -1
+else
+  limited = 0
+  reset_after = new_tat - now
+  if reset_after > 0 then
+    redis.call("SET", rate_limit_key, new_tat, "EX", math.ceil(reset_after))
+  else
+    redis.call("DEL", rate_limit_key)
+  end
+  retry_after = -1
+end
+
+return {limited, remaining, tostring(retry_after), tostring(reset_after)}
+`)
+
+	// resetScript deletes a bucket, through a script as Scripter can't issue
+	// plain commands
+	resetScript = newScript(`return redis.call("DEL", KEYS[1])`)
+
+	// slidingWindowScript is go_limiter's sliding window script, extended
+	// to record cost hits at once or to refund them
+	slidingWindowScript = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+
+local rate_limit_key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+
+redis.call("ZREMRANGEBYSCORE", rate_limit_key, "0.0", string.format("%.6f", now - period))
+
+local count = redis.call("ZCARD", rate_limit_key)
+local oldest = redis.call("ZRANGEBYSCORE", rate_limit_key, "0.0", "+inf", "WITHSCORES", "LIMIT", 0, 1)
+local retry_after = period
+
+if #oldest > 0 then
+  retry_after = period - (now - tonumber(oldest[2]))
+end
+
+-- a negative cost refunds the latest hits
+if cost < 0 then
+  redis.call("ZREMRANGEBYRANK", rate_limit_key, cost, -1)
+  return {1, rate - math.max(count + cost, 0), "-1"}
+end
+
+if count + cost > rate then
+  return {0, math.max(rate - count, 0), tostring(retry_after)}
+end
+
+-- tostring keeps 14 digits only, format the microseconds explicitly so
+-- sub-second periods keep their resolution and members stay unique
+local score = string.format("%.6f", now)
+for i = 1, cost do
+  redis.call("ZADD", rate_limit_key, score, score .. ":" .. (count + i))
+end
+-- EXPIRE only takes whole seconds
+redis.call("EXPIRE", rate_limit_key, math.ceil(period))
+
+return {1, rate - (count + cost), tostring(retry_after)}
+`)
+)
+
+// allowN consumes n tokens of limit from the redis key
+func allowN(ctx context.Context, scripter Scripter, key string, limit *go_limiter.Limit, n int64) (*go_limiter.Result, error) {
+	switch limit.Algorithm {
+	case go_limiter.GCRAAlgorithm:
+		values := []interface{}{limit.Burst, limit.Rate, limit.Period.Seconds(), n, warmUp(ctx).Seconds()}
+
+		v, err := gcraScript.run(ctx, scripter, []string{key}, values...)
+		if err != nil {
+			return nil, err
+		}
+
+		values = v.([]interface{})
+
+		retryAfter, err := strconv.ParseFloat(values[2].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		resetAfter, err := strconv.ParseFloat(values[3].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &go_limiter.Result{
+			Limit:      limit,
+			Key:        key,
+			Allowed:    values[0].(int64) == 0,
+			Remaining:  values[1].(int64),
+			RetryAfter: dur(retryAfter),
+			ResetAfter: dur(resetAfter),
+		}, nil
+	case go_limiter.SlidingWindowAlgorithm:
+		values := []interface{}{limit.Rate, limit.Period.Seconds(), n}
+
+		v, err := slidingWindowScript.run(ctx, scripter, []string{key}, values...)
+		if err != nil {
+			return nil, err
+		}
+
+		values = v.([]interface{})
+
+		retryAfter, err := strconv.ParseFloat(values[2].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &go_limiter.Result{
+			Limit:      limit,
+			Key:        key,
+			Allowed:    values[0].(int64) == 1,
+			Remaining:  values[1].(int64),
+			RetryAfter: dur(retryAfter),
+			ResetAfter: limit.Period,
+		}, nil
+	default:
+		return nil, errAlgorithmNotSupported
+	}
+}
+
+// storeKey is the redis key for key, "<prefix>:<algorithm>:<key>" with
+// the default separator, the same layout go_limiter uses
+func storeKey(prefix, separator string, algorithm uint, key string) string {
+	name, _ := go_limiter.GetAlgorithmName(algorithm)
+
+	return prefix + separator + name + separator + key
+}
+
+// dur converts seconds as returned by the scripts to a time.Duration
+func dur(f float64) time.Duration {
+	if f == -1 {
+		return -1
+	}
+
+	return time.Duration(f * float64(time.Second))
+}
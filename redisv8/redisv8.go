@@ -0,0 +1,35 @@
+// Package redisv8 adapts go-redis v8 clients to echo_limiter.Scripter
+//
+// Usage:
+//
+//	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+//
+//	e.Use(limiter.NewWithConfig(limiter.Config{
+//	  Scripter: redisv8.NewScripter(client),
+//	}))
+package redisv8
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	limiter "github.com/shareed2k/echo_limiter"
+)
+
+type scripter struct {
+	rdb redis.Cmdable
+}
+
+// NewScripter returns a Scripter for any go-redis v8 client, standalone,
+// cluster, sentinel or ring
+func NewScripter(rdb redis.Cmdable) limiter.Scripter {
+	return &scripter{rdb: rdb}
+}
+
+func (s *scripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return s.rdb.Eval(ctx, script, keys, args...).Result()
+}
+
+func (s *scripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return s.rdb.EvalSha(ctx, sha1, keys, args...).Result()
+}
@@ -0,0 +1,49 @@
+package redisv8
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	limiter "github.com/shareed2k/echo_limiter"
+)
+
+func TestScripter(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, algorithm := range []uint{limiter.SlidingWindowAlgorithm, limiter.GCRAAlgorithm} {
+		m, err := limiter.NewWithConfigE(limiter.Config{Scripter: NewScripter(client), Max: 2, Burst: 2, Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := echo.New()
+		e.Use(m)
+		e.GET("/", func(ctx echo.Context) error {
+			return ctx.String(http.StatusOK, "ok")
+		})
+
+		// The first call loads the script after NOSCRIPT
+		var got []int
+		for i := 0; i < 3; i++ {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			got = append(got, rec.Code)
+		}
+
+		if want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}; !reflect.DeepEqual(got, want) {
+			t.Errorf("algorithm %d: codes = %v, want %v", algorithm, got, want)
+		}
+	}
+}
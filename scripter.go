@@ -0,0 +1,83 @@
+package echo_limiter
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type (
+	// Scripter runs the limiter's lua scripts. go-redis v7 clients passed
+	// as Rediser are wrapped automatically, see the redisv8 subpackage for
+	// go-redis v8 and the README for other clients
+	Scripter interface {
+		// Eval runs script, returning the script's reply
+		Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+		// EvalSha runs a cached script, errors starting with NOSCRIPT are
+		// retried with Eval
+		EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error)
+	}
+
+	// script is a lua script, loaded into redis on first use
+	script struct {
+		src  string
+		hash string
+	}
+
+	// redisScripter adapts a go-redis v7 client to Scripter
+	redisScripter struct {
+		rdb redis.UniversalClient
+	}
+)
+
+func newScript(src string) *script {
+	h := sha1.Sum([]byte(src))
+
+	return &script{
+		src:  src,
+		hash: hex.EncodeToString(h[:]),
+	}
+}
+
+// run evaluates the script by hash, falling back to the source when redis
+// doesn't know it yet
+func (s *script) run(ctx context.Context, scripter Scripter, keys []string, args ...interface{}) (interface{}, error) {
+	v, err := scripter.EvalSha(ctx, s.hash, keys, args...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		return scripter.Eval(ctx, s.src, keys, args...)
+	}
+
+	return v, err
+}
+
+// NewScripter returns a Scripter for a go-redis v7 client
+func NewScripter(rdb redis.UniversalClient) Scripter {
+	return &redisScripter{rdb: rdb}
+}
+
+func (s *redisScripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return withContext(s.rdb, ctx).Eval(script, keys, args...).Result()
+}
+
+func (s *redisScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return withContext(s.rdb, ctx).EvalSha(sha1, keys, args...).Result()
+}
+
+// withContext binds ctx to the redis client so every command issued for
+// the limiter honors its deadline and cancellation
+func withContext(rdb redis.UniversalClient, ctx context.Context) redis.UniversalClient {
+	switch c := rdb.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	case *redis.Ring:
+		return c.WithContext(ctx)
+	default:
+		return rdb
+	}
+}
@@ -0,0 +1,82 @@
+package echo_limiter
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+type (
+	// shardedStore spreads the keys over shards by their hash
+	shardedStore struct {
+		shards []Store
+	}
+
+	// shardedScripter runs every script on the shard of its first key, the
+	// state besides the limits, e.g. of Escalation, with Shards
+	shardedScripter struct {
+		shards []Scripter
+	}
+)
+
+// NewShardedStore returns a Store spreading the keys over shards, e.g. one
+// redis store per instance, a key always lands on the same shard. Every
+// key is limited by its shard alone, so a limit spanning shards isn't
+// possible and changing the shards moves keys to fresh buckets
+func NewShardedStore(shards ...Store) Store {
+	return &shardedStore{shards: shards}
+}
+
+// shard returns the store of key
+func (s *shardedStore) shard(key string) Store {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// AllowN implements Store
+func (s *shardedStore) AllowN(ctx context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error) {
+	return s.shard(key).AllowN(ctx, key, limit, n)
+}
+
+// Peek implements Store
+func (s *shardedStore) Peek(ctx context.Context, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	return s.shard(key).Peek(ctx, key, limit)
+}
+
+// Reset implements Store
+func (s *shardedStore) Reset(ctx context.Context, key string) error {
+	return s.shard(key).Reset(ctx, key)
+}
+
+// shard returns the scripter of key, by its {hash tag} when it has one
+// like in a redis cluster, so the keys of a script sharing a tag land on
+// the same shard
+func (s *shardedScripter) shard(keys []string) Scripter {
+	if len(keys) == 0 {
+		return s.shards[0]
+	}
+
+	key := keys[0]
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Eval implements Scripter
+func (s *shardedScripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return s.shard(keys).Eval(ctx, script, keys, args...)
+}
+
+// EvalSha implements Scripter
+func (s *shardedScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return s.shard(keys).EvalSha(ctx, sha1, keys, args...)
+}
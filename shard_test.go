@@ -0,0 +1,95 @@
+package echo_limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/echo_limiter/testutil"
+	"github.com/shareed2k/go_limiter"
+)
+
+func TestShardedStore(t *testing.T) {
+	shards := []*testutil.Store{testutil.AllowAll(), testutil.AllowAll(), testutil.AllowAll()}
+	store := NewShardedStore(shards[0], shards[1], shards[2])
+	limit := &go_limiter.Limit{Rate: 10, Period: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		for k := 0; k < 30; k++ {
+			if _, err := store.AllowN(context.Background(), fmt.Sprint("key", k), limit, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	for i, shard := range shards {
+		calls := shard.Calls()
+		if len(calls) == 0 {
+			t.Errorf("shard %d got no keys", i)
+		}
+
+		for _, call := range calls {
+			if shard, ok := seen[call.Key]; ok && shard != i {
+				t.Errorf("key %s on shards %d and %d", call.Key, shard, i)
+			}
+			seen[call.Key] = i
+		}
+	}
+
+	if len(seen) != 30 {
+		t.Errorf("%d keys seen, want 30", len(seen))
+	}
+}
+
+func TestShards(t *testing.T) {
+	mrA, clientA := newRedis(t)
+	mrB, clientB := newRedis(t)
+
+	e := newServer(t, Config{
+		Shards:     []redis.UniversalClient{clientA, clientB},
+		Max:        1,
+		Escalation: []EscalationRule{{Violations: 1, Window: time.Minute, Ban: time.Minute}},
+	})
+
+	for k := 0; k < 20; k++ {
+		ip := fmt.Sprint("198.51.100.", k)
+		codes(e, 3, echo.HeaderXRealIP, ip)
+	}
+
+	for _, mr := range []*miniredis.Miniredis{mrA, mrB} {
+		if len(mr.Keys()) == 0 {
+			t.Error("a shard got no keys")
+		}
+	}
+
+	for _, key := range mrA.Keys() {
+		if mrB.Exists(key) {
+			t.Errorf("key %s on both shards", key)
+		}
+	}
+
+	if got := serve(e, http.MethodGet, "/", echo.HeaderXRealIP, "198.51.100.1").Code; got != http.StatusTooManyRequests {
+		t.Errorf("code = %d, want 429", got)
+	}
+}
+
+func TestShardedScripterHashTag(t *testing.T) {
+	shards := make([]Scripter, 8)
+	for i := range shards {
+		shards[i] = NewScripter(nil)
+	}
+	s := &shardedScripter{shards: shards}
+
+	for k := 0; k < 20; k++ {
+		tag := fmt.Sprint("{key", k, "}")
+		if a, b := s.shard([]string{"ban:" + tag}), s.shard([]string{"violations:60:" + tag}); a != b {
+			t.Errorf("keys of %s on different shards", tag)
+		}
+	}
+}
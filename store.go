@@ -0,0 +1,134 @@
+package echo_limiter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/shareed2k/go_limiter"
+)
+
+type (
+	// Store keeps the state of the limits. The redis store is used by
+	// default, NewMemoryStore is a single instance alternative, any other
+	// backend can be plugged in through Config.Store
+	Store interface {
+		// AllowN consumes n tokens of limit from key, a negative n refunds
+		// tokens consumed before. The result has to be the state right
+		// after this call, read in the same atomic step as the decision,
+		// as the headers are built from it
+		AllowN(ctx context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error)
+
+		// Peek returns the state of key without consuming, Allowed reports
+		// whether the next request would be allowed
+		Peek(ctx context.Context, key string, limit *go_limiter.Limit) (*go_limiter.Result, error)
+
+		// Reset drops the state of key, so it starts fresh
+		Reset(ctx context.Context, key string) error
+	}
+
+	// BatchStore is a Store checking several limits at once, see
+	// Config.Batch
+	BatchStore interface {
+		Store
+
+		// AllowAllN consumes n tokens from the keys in order until one of
+		// them denies, the keys after it are only peeked. There is a result
+		// for every key, the same ones as AllowN and Peek would return
+		AllowAllN(ctx context.Context, keys []string, limits []*go_limiter.Limit, n int) ([]*go_limiter.Result, error)
+	}
+
+	// warmUpKey holds Config.WarmUp in the context of the Store calls
+	warmUpKey struct{}
+
+	// redisStore runs the limits as lua scripts on redis
+	redisStore struct {
+		scripter Scripter
+	}
+)
+
+// NewRedisStore returns a Store keeping the limits in redis, in the same
+// layout as go_limiter
+func NewRedisStore(scripter Scripter) Store {
+	return &redisStore{scripter: scripter}
+}
+
+// AllowN implements Store, the result is the same as go_limiter's Allow
+// for n = 1
+func (s *redisStore) AllowN(ctx context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error) {
+	return allowN(ctx, s.scripter, key, limit, int64(n))
+}
+
+// Peek implements Store
+func (s *redisStore) Peek(ctx context.Context, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	return peek(ctx, s.scripter, key, limit)
+}
+
+// Reset implements Store
+func (s *redisStore) Reset(ctx context.Context, key string) error {
+	_, err := resetScript.run(ctx, s.scripter, []string{key})
+	return err
+}
+
+// Reset clears the counters of key for both algorithms, so the next
+// request starts fresh, e.g. after a plan upgrade. prefix is the
+// Config.Prefix of the middleware the key belongs to, preceded by
+// "<Namespace>:" when a Namespace is set. With IncludePath the
+// key is "<route path>:<key>", with more than one of Config.Limits every
+// rule is kept under "<key>:<period>", e.g. "1.2.3.4:1m0s". An Escalation
+// ban of key is lifted and its violations dropped too, found with SCAN
+// as their keys carry their rule, "<violations>:<window>:<ban>"
+func Reset(rediser redis.UniversalClient, prefix, key string) error {
+	store := NewRedisStore(NewScripter(rediser))
+
+	for _, algorithm := range []uint{GCRAAlgorithm, SlidingWindowAlgorithm} {
+		if err := store.Reset(context.Background(), storeKey(prefix, defaultKeySeparator, algorithm, key)); err != nil {
+			return err
+		}
+	}
+
+	tag := "{" + key + "}"
+	if err := store.Reset(context.Background(), prefix+defaultKeySeparator+"ban"+defaultKeySeparator+tag); err != nil {
+		return err
+	}
+
+	// The rule part spans several separators, the * matches all of them
+	pattern := escapeGlob(prefix+defaultKeySeparator+"violations"+defaultKeySeparator) + "*" + escapeGlob(defaultKeySeparator+tag)
+
+	return forEachNode(rediser, func(client *redis.Client) error {
+		iter := client.Scan(0, pattern, 100).Iterator()
+		for iter.Next() {
+			if err := client.Del(iter.Val()).Err(); err != nil {
+				return err
+			}
+		}
+
+		return iter.Err()
+	})
+}
+
+// escapeGlob escapes the characters special to the patterns of SCAN
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// withWarmUp returns ctx carrying the warm-up d for fresh gcra keys
+func withWarmUp(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, warmUpKey{}, d)
+}
+
+// warmUp returns the warm-up of ctx, 0 when there is none
+func warmUp(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(warmUpKey{}).(time.Duration)
+	return d
+}
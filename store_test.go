@@ -0,0 +1,107 @@
+package echo_limiter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+// testStore runs the Store contract against the fresh key of store for
+// limit, with a rate and burst of 3
+func testStore(t *testing.T, store Store, key string, limit *go_limiter.Limit) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	steps := []struct {
+		name          string
+		n             int
+		peek, reset   bool
+		wantAllowed   bool
+		wantRemaining int64
+	}{
+		{name: "first", n: 1, wantAllowed: true, wantRemaining: 2},
+		{name: "cost", n: 2, wantAllowed: true, wantRemaining: 0},
+		{name: "empty", n: 1, wantAllowed: false},
+		{name: "peek", peek: true, wantAllowed: false},
+		{name: "refund", n: -1, wantAllowed: true, wantRemaining: 1},
+		{name: "refunded", n: 1, wantAllowed: true, wantRemaining: 0},
+		{name: "reset", reset: true, n: 3, wantAllowed: true, wantRemaining: 0},
+		{name: "over burst", n: 4, wantAllowed: false},
+	}
+
+	for _, step := range steps {
+		if step.reset {
+			if err := store.Reset(ctx, key); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+
+		var (
+			r   *go_limiter.Result
+			err error
+		)
+		if step.peek {
+			r, err = store.Peek(ctx, key, limit)
+		} else {
+			r, err = store.AllowN(ctx, key, limit, step.n)
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if r.Allowed != step.wantAllowed {
+			t.Errorf("%s: allowed = %v, want %v", step.name, r.Allowed, step.wantAllowed)
+		}
+
+		if step.wantAllowed && !step.peek && r.Remaining != step.wantRemaining {
+			t.Errorf("%s: remaining = %d, want %d", step.name, r.Remaining, step.wantRemaining)
+		}
+
+		if !r.Allowed && r.RetryAfter <= 0 {
+			t.Errorf("%s: retry after = %s, want a wait", step.name, r.RetryAfter)
+		}
+	}
+}
+
+func TestStores(t *testing.T) {
+	_, client := newRedis(t)
+
+	stores := []struct {
+		name  string
+		store func() Store
+	}{
+		{"memory", NewMemoryStore},
+		{"redis", func() Store { return NewRedisStore(NewScripter(client)) }},
+	}
+
+	for _, s := range stores {
+		for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+			name, _ := go_limiter.GetAlgorithmName(algorithm)
+			t.Run(s.name+"/"+name, func(t *testing.T) {
+				testStore(t, s.store(), name, &go_limiter.Limit{Rate: 3, Burst: 3, Period: time.Minute, Algorithm: algorithm})
+			})
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	_, client := newRedis(t)
+
+	for _, algorithm := range []uint{SlidingWindowAlgorithm, GCRAAlgorithm} {
+		e := newServer(t, Config{Rediser: client, Max: 1, Burst: 1, Period: time.Hour, Algorithm: algorithm})
+		codes(e, 2)
+
+		if err := Reset(client, DefaultKeyPrefix, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := codes(e, 1)[0]; got != http.StatusOK {
+			t.Errorf("algorithm %d: code after reset = %d, want 200", algorithm, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package echo_limiter
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// SweepExpired deletes the keys under prefix that never expire and returns
+// how many it deleted. The limiter scripts expire every key once it's
+// stale, after the period with sliding window and after the reset with
+// gcra, so there is no separate TTL to tune. Keys without a TTL are left
+// by anything else writing under prefix, e.g. a failed migration or a
+// manual SET, and would linger forever on a shared redis. prefix is like
+// for Reset, cluster masters and ring shards are swept one by one
+func SweepExpired(rediser redis.UniversalClient, prefix string) (int, error) {
+	var n int64
+
+	sweep := func(client *redis.Client) error {
+		iter := client.Scan(0, prefix+defaultKeySeparator+"*", 100).Iterator()
+		for iter.Next() {
+			ttl, err := client.TTL(iter.Val()).Result()
+			if err != nil {
+				return err
+			}
+
+			// -1 is a key without expiry, -2 one gone in the meantime
+			if ttl != -1 {
+				continue
+			}
+
+			if err := client.Del(iter.Val()).Err(); err != nil {
+				return err
+			}
+
+			atomic.AddInt64(&n, 1)
+		}
+
+		return iter.Err()
+	}
+
+	err := forEachNode(rediser, sweep)
+
+	return int(atomic.LoadInt64(&n)), err
+}
+
+// forEachNode calls f with every cluster master or ring shard of rediser,
+// or rediser itself, for commands like SCAN that only see one node
+func forEachNode(rediser redis.UniversalClient, f func(*redis.Client) error) error {
+	switch c := rediser.(type) {
+	case *redis.ClusterClient:
+		return c.ForEachMaster(f)
+	case *redis.Ring:
+		return c.ForEachShard(f)
+	case *redis.Client:
+		return f(c)
+	}
+
+	return errors.New("unsupported redis client")
+}
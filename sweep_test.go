@@ -0,0 +1,45 @@
+package echo_limiter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestSweepExpired(t *testing.T) {
+	mr, client := newRedis(t)
+
+	e := newServer(t, Config{Rediser: client})
+	codes(e, 1)
+
+	_ = mr.Set("echo_limiter:stale", "1")
+	_ = mr.Set("echo_limiter:live", "1")
+	mr.SetTTL("echo_limiter:live", time.Minute)
+	_ = mr.Set("other:stale", "1")
+
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"a": mr.Addr()}})
+	defer ring.Close()
+
+	tests := []struct {
+		name   string
+		client redis.UniversalClient
+		want   int
+	}{
+		{"client", client, 1},
+		{"ring, already swept", ring, 0},
+	}
+
+	for _, tt := range tests {
+		n, err := SweepExpired(tt.client, DefaultKeyPrefix)
+		if err != nil || n != tt.want {
+			t.Errorf("%s: swept %d (%v), want %d", tt.name, n, err, tt.want)
+		}
+	}
+
+	want := []string{"echo_limiter:live", "echo_limiter:sliding_window:192.0.2.1", "other:stale"}
+	if got := mr.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package testutil_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	limiter "github.com/shareed2k/echo_limiter"
+	"github.com/shareed2k/echo_limiter/testutil"
+)
+
+// newServer returns an echo server limited by store
+func newServer(store limiter.Store) *echo.Echo {
+	e := echo.New()
+	e.Use(limiter.NewWithStore(store))
+	e.GET("/", func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, "ok")
+	})
+
+	return e
+}
+
+func ExampleAllowAll() {
+	store := testutil.AllowAll()
+	e := newServer(store)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		fmt.Println(rec.Code)
+	}
+
+	fmt.Println(len(store.Calls()))
+	// Output:
+	// 200
+	// 200
+	// 200
+	// 3
+}
+
+func ExampleAllowThenDeny() {
+	store := testutil.AllowThenDeny(2)
+	e := newServer(store)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		fmt.Println(rec.Code)
+	}
+
+	call := store.Calls()[0]
+	fmt.Println(call.Key, call.Limit.Rate, call.N)
+	// Output:
+	// 200
+	// 200
+	// 429
+	// echo_limiter:sliding_window:192.0.2.1 10 1
+}
@@ -0,0 +1,128 @@
+// Package testutil provides a fake echo_limiter.Store, so handlers behind
+// the middleware can be tested without redis
+//
+//	store := testutil.AllowThenDeny(2)
+//	e.Use(limiter.NewWithStore(store))
+//
+//	// ... two requests pass, the third one gets a 429
+//
+//	calls := store.Calls()
+package testutil
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shareed2k/go_limiter"
+)
+
+type (
+	// Call is a single AllowN call seen by Store
+	Call struct {
+		Key   string
+		Limit *go_limiter.Limit
+		N     int
+	}
+
+	// Store is a fake echo_limiter.Store that allows a fixed number of
+	// tokens per key and records every call
+	Store struct {
+		mu    sync.Mutex
+		allow int
+		used  map[string]int
+		calls []Call
+	}
+)
+
+// AllowAll returns a Store that never denies
+func AllowAll() *Store {
+	return AllowThenDeny(-1)
+}
+
+// AllowThenDeny returns a Store that allows n tokens per key and denies
+// once they are used
+func AllowThenDeny(n int) *Store {
+	return &Store{
+		allow: n,
+		used:  make(map[string]int),
+	}
+}
+
+// AllowN implements echo_limiter.Store
+func (s *Store) AllowN(_ context.Context, key string, limit *go_limiter.Limit, n int) (*go_limiter.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, Call{Key: key, Limit: limit, N: n})
+
+	result := s.result(key, limit)
+	if s.allow >= 0 && s.used[key]+n > s.allow {
+		result.Allowed = false
+		result.RetryAfter = limit.Period
+
+		return result, nil
+	}
+
+	s.used[key] += n
+	if s.used[key] < 0 {
+		// Refunded more than was used
+		s.used[key] = 0
+	}
+
+	result = s.result(key, limit)
+	result.Allowed = true
+	result.RetryAfter = -1
+
+	return result, nil
+}
+
+// Peek implements echo_limiter.Store
+func (s *Store) Peek(_ context.Context, key string, limit *go_limiter.Limit) (*go_limiter.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := s.result(key, limit)
+	result.Allowed = s.allow < 0 || result.Remaining > 0
+	result.RetryAfter = -1
+
+	if !result.Allowed {
+		result.RetryAfter = limit.Period
+	}
+
+	return result, nil
+}
+
+// Reset implements echo_limiter.Store
+func (s *Store) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.used, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Calls returns the AllowN calls seen so far
+func (s *Store) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call(nil), s.calls...)
+}
+
+func (s *Store) result(key string, limit *go_limiter.Limit) *go_limiter.Result {
+	remaining := limit.Rate - int64(s.used[key])
+	if s.allow >= 0 {
+		remaining = int64(s.allow - s.used[key])
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &go_limiter.Result{
+		Limit:      limit,
+		Key:        key,
+		Remaining:  remaining,
+		ResetAfter: limit.Period,
+	}
+}
@@ -0,0 +1,28 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IsWebSocketUpgrade reports whether the request is a WebSocket handshake,
+// a GET with "Connection: upgrade" and "Upgrade: websocket", see
+// Config.WebSocketOnly
+func IsWebSocketUpgrade(ctx echo.Context) bool {
+	req := ctx.Request()
+	if req.Method != http.MethodGet || !strings.EqualFold(strings.TrimSpace(req.Header.Get(echo.HeaderUpgrade)), "websocket") {
+		return false
+	}
+
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package echo_limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shareed2k/echo_limiter/testutil"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		name                string
+		method              string
+		connection, upgrade string
+		want                bool
+	}{
+		{"handshake", http.MethodGet, "Upgrade", "websocket", true},
+		{"token list", http.MethodGet, "keep-alive, Upgrade", "WebSocket", true},
+		{"plain get", http.MethodGet, "", "", false},
+		{"other protocol", http.MethodGet, "Upgrade", "h2c", false},
+		{"no connection", http.MethodGet, "", "websocket", false},
+		{"post", http.MethodPost, "Upgrade", "websocket", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Connection", tt.connection)
+			req.Header.Set(echo.HeaderUpgrade, tt.upgrade)
+
+			if got := IsWebSocketUpgrade(echo.New().NewContext(req, httptest.NewRecorder())); got != tt.want {
+				t.Errorf("IsWebSocketUpgrade = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketOnly(t *testing.T) {
+	store := testutil.AllowThenDeny(1)
+	e := newServer(t, Config{Store: store, WebSocketOnly: true})
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"handshake", []string{"Connection", "Upgrade", echo.HeaderUpgrade, "websocket"}, http.StatusOK},
+		{"get", nil, http.StatusOK},
+		{"reconnect", []string{"Connection", "Upgrade", echo.HeaderUpgrade, "websocket"}, http.StatusTooManyRequests},
+		{"get again", nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := serve(e, http.MethodGet, "/ws", tt.headers...).Code; got != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if calls := store.Calls(); len(calls) != 2 {
+		t.Errorf("store calls = %d, want 2 handshakes", len(calls))
+	}
+}